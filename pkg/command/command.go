@@ -153,13 +153,13 @@ func (c *Command) AddOutputWriter(writer io.Writer) *Command {
 // the command execution was not possible at all, otherwise the Status.
 // This method prints the commands output during execution
 func (c *Command) Run() (res *Status, err error) {
-	return c.run(true)
+	return c.run(true, true)
 }
 
 // RunSuccessOutput starts the command and waits for it to finish. It returns
 // an error if the command execution was not successful, otherwise its output.
 func (c *Command) RunSuccessOutput() (output *Stream, err error) {
-	res, err := c.run(true)
+	res, err := c.run(true, true)
 	if err != nil {
 		return nil, err
 	}
@@ -197,7 +197,14 @@ func (c *Command) String() string {
 // the command execution was not possible at all, otherwise the Status.
 // This method does not print the output of the command during its execution.
 func (c *Command) RunSilent() (res *Status, err error) {
-	return c.run(false)
+	return c.run(false, false)
+}
+
+// RunSilentWithWriters starts the command and waits for it to finish. Like
+// RunSilent it does not print the output of the command, but it still passes
+// the output to the additional writers during its execution.
+func (c *Command) RunSilentWithWriters() (res *Status, err error) {
+	return c.run(false, true)
 }
 
 // RunSilentSuccessOutput starts the command and waits for it to finish. It
@@ -205,7 +212,7 @@ func (c *Command) RunSilent() (res *Status, err error) {
 // output. This method does not print the output of the command during its
 // execution.
 func (c *Command) RunSilentSuccessOutput() (output *Stream, err error) {
-	res, err := c.run(false)
+	res, err := c.run(false, false)
 	if err != nil {
 		return nil, err
 	}
@@ -223,8 +230,9 @@ func (c *Command) RunSilentSuccess() error {
 	return err
 }
 
-// run is the internal run method
-func (c *Command) run(printOutput bool) (res *Status, err error) {
+// run is the internal run method. The additional writers are only used if
+// writeOutput is set.
+func (c *Command) run(printOutput, writeOutput bool) (res *Status, err error) {
 	var runErr error
 	stdOutBuffer := &bytes.Buffer{}
 	stdErrBuffer := &bytes.Buffer{}
@@ -250,14 +258,22 @@ func (c *Command) run(printOutput bool) (res *Status, err error) {
 			}
 
 			var stdErrWriter io.Writer
-			if printOutput {
+			switch {
+			case printOutput:
 				stdOutWriter = io.MultiWriter(append(
 					[]io.Writer{os.Stdout, stdOutBuffer}, c.stdOutWriters...,
 				)...)
 				stdErrWriter = io.MultiWriter(append(
 					[]io.Writer{os.Stderr, stdErrBuffer}, c.stdErrWriters...,
 				)...)
-			} else {
+			case writeOutput:
+				stdOutWriter = io.MultiWriter(append(
+					[]io.Writer{stdOutBuffer}, c.stdOutWriters...,
+				)...)
+				stdErrWriter = io.MultiWriter(append(
+					[]io.Writer{stdErrBuffer}, c.stdErrWriters...,
+				)...)
+			default:
 				stdOutWriter = stdOutBuffer
 				stdErrWriter = stdErrBuffer
 			}
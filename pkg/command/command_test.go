@@ -252,6 +252,18 @@ func TestSuccessLogWriterSilent(t *testing.T) {
 	require.Empty(t, content)
 }
 
+func TestSuccessLogWriterSilentWithWriters(t *testing.T) {
+	b := &bytes.Buffer{}
+
+	res, err := New("sh", "-c", "echo out; echo err >&2").AddWriter(b).RunSilentWithWriters()
+	require.Nil(t, err)
+	require.True(t, res.Success())
+	require.Equal(t, "out\n", res.Output())
+	require.Equal(t, "err\n", res.Error())
+	require.Contains(t, b.String(), "out\n")
+	require.Contains(t, b.String(), "err\n")
+}
+
 func TestSuccessLogWriterStdErr(t *testing.T) {
 	f, err := ioutil.TempFile("", "log")
 	require.Nil(t, err)
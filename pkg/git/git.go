@@ -256,6 +256,11 @@ func (r *Repo) Dir() string {
 	return r.dir
 }
 
+// IsBare returns true if the repository has no worktree
+func (r *Repo) IsBare() bool {
+	return r.worktree == nil
+}
+
 // Set the repo into dry run mode, which does not modify any remote locations
 // at all.
 func (r *Repo) SetDry() {
@@ -422,6 +427,15 @@ func OpenRepo(repoPath string) (*Repo, error) {
 	}
 
 	worktree, err := r.Worktree()
+	if errors.Is(err, git.ErrIsBareRepository) {
+		// Bare repositories have no worktree, only the operations on refs
+		// and objects are usable
+		dir, err := filepath.Abs(repoPath)
+		if err != nil {
+			return nil, errors.Wrap(err, "getting absolute repository path")
+		}
+		return &Repo{inner: r, dir: dir}, nil
+	}
 	if err != nil {
 		return nil, errors.Wrap(err, "getting repository worktree")
 	}
@@ -1003,6 +1017,9 @@ func (r *Repo) Commit(msg string) error {
 
 // CommitWithOptions commits the current repository state
 func (r *Repo) CommitWithOptions(msg string, options *git.CommitOptions) error {
+	if r.IsBare() {
+		return errors.New("cannot commit in a bare repository")
+	}
 	if _, err := r.worktree.Commit(msg, options); err != nil {
 		return err
 	}
@@ -1248,6 +1265,9 @@ func (r *Repo) SetURL(remote, newURL string) error {
 
 // Status reads and returns the Status object from the repository
 func (r *Repo) Status() (*git.Status, error) {
+	if r.IsBare() {
+		return nil, errors.New("bare repository has no worktree status")
+	}
 	status, err := r.worktree.Status()
 	if err != nil {
 		return nil, errors.Wrap(err, "getting the repository status")
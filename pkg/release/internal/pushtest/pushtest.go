@@ -0,0 +1,121 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package pushtest provides git repositories backed by a local bare remote to
+// test the git object pusher end to end without a git server.
+package pushtest
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"k8s.io/release/pkg/command"
+	"k8s.io/release/pkg/git"
+)
+
+// Repo is a local repository with a root commit on the default branch and a
+// bare repository configured as its default remote
+type Repo struct {
+	// Path of the local repository
+	Path string
+
+	// Path of the bare repository used as remote
+	RemotePath string
+}
+
+// NewRepo creates a new repository and its remote in temporary directories
+// which are removed when the test finishes
+func NewRepo(t *testing.T) *Repo {
+	t.Helper()
+	repo := &Repo{Path: tempDir(t, "repo"), RemotePath: tempDir(t, "remote")}
+
+	run(t, repo.RemotePath, "init", "--bare")
+	run(t, repo.Path, "init")
+	run(t, repo.Path, "checkout", "-b", git.DefaultBranch)
+	run(t, repo.Path, "commit", "--allow-empty", "-m", "Root commit")
+	run(t, repo.Path, "remote", "add", git.DefaultRemote, repo.RemotePath)
+	return repo
+}
+
+// Git runs git in the local repository and returns its trimmed output
+func (r *Repo) Git(t *testing.T, args ...string) string {
+	t.Helper()
+	return run(t, r.Path, args...)
+}
+
+// RemoteGit runs git in the remote repository and returns its trimmed output
+func (r *Repo) RemoteGit(t *testing.T, args ...string) string {
+	t.Helper()
+	return run(t, r.RemotePath, args...)
+}
+
+// Commit writes a file in the local repository, commits it and returns the
+// SHA of the new commit
+func (r *Repo) Commit(t *testing.T, fileName, content string) string {
+	t.Helper()
+	require.Nil(t, ioutil.WriteFile(
+		filepath.Join(r.Path, fileName), []byte(content), os.FileMode(0o644),
+	))
+	r.Git(t, "add", fileName)
+	r.Git(t, "commit", "-m", "Update "+fileName)
+	return r.Git(t, "rev-parse", "HEAD")
+}
+
+// RemoteRefs returns the refs in the remote mapped to the SHA they point to
+func (r *Repo) RemoteRefs(t *testing.T) map[string]string {
+	t.Helper()
+	refs := map[string]string{}
+	output := r.RemoteGit(t, "for-each-ref", "--format=%(refname) %(objectname)")
+	for _, line := range strings.Split(output, "\n") {
+		if fields := strings.Fields(line); len(fields) == 2 {
+			refs[fields[0]] = fields[1]
+		}
+	}
+	return refs
+}
+
+// RequireRemoteRef fails the test if the ref does not exist in the remote
+func (r *Repo) RequireRemoteRef(t *testing.T, ref string) {
+	t.Helper()
+	require.Contains(t, r.RemoteRefs(t), ref, "ref %s missing in the remote", ref)
+}
+
+// RequireNoRemoteRef fails the test if the ref exists in the remote
+func (r *Repo) RequireNoRemoteRef(t *testing.T, ref string) {
+	t.Helper()
+	require.NotContains(t, r.RemoteRefs(t), ref, "ref %s found in the remote", ref)
+}
+
+// tempDir creates a temporary directory removed when the test finishes
+func tempDir(t *testing.T, name string) string {
+	t.Helper()
+	dir, err := ioutil.TempDir(os.TempDir(), "sigrelease-pushtest-"+name+"-*")
+	require.Nil(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
+// run runs git in a directory and returns its trimmed output
+func run(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	output, err := command.NewWithWorkDir(dir, "git", args...).RunSilentSuccessOutput()
+	require.Nil(t, err, "running git %s", strings.Join(args, " "))
+	return output.OutputTrimNL()
+}
@@ -0,0 +1,446 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package release
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	branchRefPrefix = "refs/heads/"
+	tagRefPrefix    = "refs/tags/"
+
+	// peeledRefSuffix is appended by ls-remote to the ref name of the commit
+	// an annotated tag points to
+	peeledRefSuffix = "^{}"
+)
+
+// AuditReport contains the policy violations found in the remote repository
+type AuditReport struct {
+	// Number of release branches checked
+	Branches int
+
+	// Number of tags checked
+	Tags int
+
+	// Problems found in the remote refs, sorted by ref name
+	Findings []AuditFinding
+}
+
+// AuditFinding is a policy violation found in a remote ref
+type AuditFinding struct {
+	// Full name of the offending ref, eg refs/tags/v1.20.0
+	Ref string
+
+	// Description of the violated policy
+	Problem string
+}
+
+// Passed returns true if the audit found no policy violations
+func (r *AuditReport) Passed() bool {
+	return len(r.Findings) == 0
+}
+
+// String returns a human readable representation of the report
+func (r *AuditReport) String() string {
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf(
+		"Audited %d release branches and %d tags, %d problems found\n",
+		r.Branches, r.Tags, len(r.Findings),
+	))
+	for _, finding := range r.Findings {
+		sb.WriteString(fmt.Sprintf("- %s: %s\n", finding.Ref, finding.Problem))
+	}
+	return sb.String()
+}
+
+// Audit checks the release branches and tags in the remote repository against
+// the pusher validation policies without pushing anything. Tags are checked
+// for valid names, for being reachable from their release branch and, when
+// RequireSignedTags is set, for carrying a signature. Checks which need the
+// git objects are only performed when the objects exist in the local repo.
+func (gp *GitObjectPusher) Audit() (*AuditReport, error) {
+	refs, err := gp.remoteRefs(gp.remote())
+	if err != nil {
+		return nil, errors.Wrap(err, "listing remote references")
+	}
+
+	report := &AuditReport{Findings: []AuditFinding{}}
+	addFinding := func(ref, problem string) {
+		report.Findings = append(report.Findings, AuditFinding{Ref: ref, Problem: problem})
+	}
+
+	for ref := range refs {
+		if !strings.HasPrefix(ref, branchRefPrefix) ||
+			!gp.isReleaseBranch(strings.TrimPrefix(ref, branchRefPrefix)) {
+			continue
+		}
+		report.Branches++
+		if err := gp.checkBranchName(strings.TrimPrefix(ref, branchRefPrefix)); err != nil {
+			addFinding(ref, fmt.Sprintf("invalid release branch name: %v", err))
+		}
+	}
+
+	for ref, sha := range refs {
+		if !strings.HasPrefix(ref, gp.tagNamespace()) || strings.HasSuffix(ref, peeledRefSuffix) {
+			continue
+		}
+		report.Tags++
+		tagName := strings.TrimPrefix(ref, gp.tagNamespace())
+		if err := gp.checkTagName(tagName); err != nil {
+			addFinding(ref, fmt.Sprintf("invalid tag name: %v", err))
+			continue
+		}
+
+		// Annotated tags point to a tag object, the commit is the peeled ref
+		commit, annotated := refs[ref+peeledRefSuffix]
+		if !annotated {
+			commit = sha
+		}
+
+		if gp.opts.RequireSignedTags {
+			if !annotated {
+				addFinding(ref, "tag is not signed: lightweight tags cannot carry a signature")
+			} else if gp.hasObject(sha) {
+				signed, err := gp.isSignedTag(sha)
+				if err != nil {
+					return nil, errors.Wrapf(err, "checking signature of tag %s", tagName)
+				}
+				if !signed {
+					addFinding(ref, "tag is not signed")
+				}
+			} else {
+				logrus.Debugf("Tag object of %s not found locally, skipping signature check", tagName)
+			}
+		}
+
+		// Tags have to be reachable from their release branch, if it exists.
+		// Tags which are not semantic versions do not belong to any.
+		branch, err := gp.ReleaseBranchForTag(tagName)
+		if err != nil {
+			if !gp.requiresSemver(tagName) {
+				continue
+			}
+			return nil, err
+		}
+		branchRef := branchRefPrefix + branch
+		branchSHA, ok := refs[branchRef]
+		if !ok {
+			continue
+		}
+		if !gp.hasObject(commit) || !gp.hasObject(branchSHA) {
+			logrus.Debugf("Commits of %s not found locally, skipping branch check", tagName)
+			continue
+		}
+		if _, err := gp.runGit("merge-base", "--is-ancestor", commit, branchSHA); err != nil {
+			addFinding(ref, fmt.Sprintf(
+				"tag is not reachable from %s", strings.TrimPrefix(branchRef, branchRefPrefix),
+			))
+		}
+	}
+
+	sort.SliceStable(report.Findings, func(i, j int) bool {
+		return report.Findings[i].Ref < report.Findings[j].Ref
+	})
+
+	logrus.Infof(
+		"Audit of %s finished with %d problems found",
+		displayRemote(gp.remote()), len(report.Findings),
+	)
+	return report, nil
+}
+
+// ValidateLocalBranches returns the local branches named like release
+// branches, ie starting with the release branch prefix or parsed by
+// VersionForBranchName, whose names are not valid, eg "release-1.x". Other
+// branches like the default one are not checked. Nothing is modified.
+func (gp *GitObjectPusher) ValidateLocalBranches() ([]string, error) {
+	output, err := gp.runGit("for-each-ref", "--format=%(refname:strip=2)", branchRefPrefix)
+	if err != nil {
+		return nil, errors.Wrap(err, "listing local branches")
+	}
+
+	invalid := []string{}
+	for _, branch := range strings.Fields(output) {
+		if !gp.isReleaseBranch(branch) {
+			continue
+		}
+		if err := gp.checkBranchName(branch); err != nil {
+			logrus.Warnf("Local branch %s has an invalid name: %v", branch, err)
+			invalid = append(invalid, branch)
+		}
+	}
+	sort.Strings(invalid)
+	return invalid, nil
+}
+
+// ValidateLocalTags returns the local tags whose names are not valid, like
+// ValidateLocalBranches does for the release branches. Nothing is modified.
+func (gp *GitObjectPusher) ValidateLocalTags() ([]string, error) {
+	tags, err := gp.localTags()
+	if err != nil {
+		return nil, errors.Wrap(err, "listing local tags")
+	}
+
+	invalid := []string{}
+	for _, tag := range tags {
+		if err := gp.checkTagName(tag); err != nil {
+			logrus.Warnf("Local tag %s has an invalid name: %v", tag, err)
+			invalid = append(invalid, tag)
+		}
+	}
+	sort.Strings(invalid)
+	return invalid, nil
+}
+
+// remoteRefs returns the branches and tags in a remote mapped to the SHA
+// they point to. Annotated tags also have their peeled ref listed.
+func (gp *GitObjectPusher) remoteRefs(remote string) (map[string]string, error) {
+	var (
+		output string
+		err    error
+	)
+	if gp.tagNamespace() != tagRefPrefix || gp.remoteNamespacePrefix() != "" {
+		output, err = gp.lsRemote(remote, branchRefPrefix+"*", gp.tagNamespace()+"*")
+	} else {
+		output, err = gp.runRemoteGit(remote, "ls-remote", "--heads", "--tags", remote)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(
+			maskCredentials(err), "running ls-remote on %s", displayRemote(remote),
+		)
+	}
+
+	return parseLsRemote(output), nil
+}
+
+// parseLsRemote maps the refs listed in the output of ls-remote to the SHA
+// they point to
+func parseLsRemote(output string) map[string]string {
+	refs := map[string]string{}
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		refs[fields[1]] = fields[0]
+	}
+	return refs
+}
+
+// hasObject returns true if the object with the specified SHA exists in the
+// local repository
+func (gp *GitObjectPusher) hasObject(sha string) bool {
+	_, err := gp.runGit("cat-file", "-e", sha)
+	return err == nil
+}
+
+// isSignedTag checks if the tag object with the specified SHA embeds a
+// signature
+func (gp *GitObjectPusher) isSignedTag(sha string) (bool, error) {
+	content, err := gp.runGit("cat-file", "tag", sha)
+	if err != nil {
+		return false, errors.Wrap(err, "reading tag object")
+	}
+	for _, header := range []string{
+		"-----BEGIN PGP SIGNATURE-----", "-----BEGIN SSH SIGNATURE-----",
+	} {
+		if strings.Contains(content, header) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// TagDiff lists the tags which only exist on one side, local or remote
+type TagDiff struct {
+	// Tags in the local repository missing in the remote, sorted
+	LocalOnly []string
+
+	// Tags in the remote missing in the local repository, eg created out of
+	// band by someone else, sorted
+	RemoteOnly []string
+}
+
+// DiffTags compares the tags in the local repository with the ones in the
+// remote. If fetchRemoteOnly is set, the tags only found in the remote are
+// fetched into the local repository, otherwise nothing is modified.
+func (gp *GitObjectPusher) DiffTags(fetchRemoteOnly bool) (*TagDiff, error) {
+	refs, err := gp.remoteRefs(gp.remote())
+	if err != nil {
+		return nil, errors.Wrap(err, "listing remote references")
+	}
+	localTags, err := gp.localTags()
+	if err != nil {
+		return nil, errors.Wrap(err, "listing local tags")
+	}
+
+	diff := &TagDiff{LocalOnly: []string{}, RemoteOnly: []string{}}
+	local := map[string]bool{}
+	for _, tag := range localTags {
+		local[tag] = true
+		if _, ok := refs[gp.tagRef(tag)]; !ok {
+			diff.LocalOnly = append(diff.LocalOnly, tag)
+		}
+	}
+	for ref := range refs {
+		if !strings.HasPrefix(ref, gp.tagNamespace()) || strings.HasSuffix(ref, peeledRefSuffix) {
+			continue
+		}
+		if tag := strings.TrimPrefix(ref, gp.tagNamespace()); !local[tag] {
+			diff.RemoteOnly = append(diff.RemoteOnly, tag)
+		}
+	}
+	sort.Strings(diff.LocalOnly)
+	sort.Strings(diff.RemoteOnly)
+
+	for _, tag := range diff.RemoteOnly {
+		logrus.Warnf("Tag %s exists in %s but not locally", tag, displayRemote(gp.remote()))
+	}
+	if !fetchRemoteOnly || len(diff.RemoteOnly) == 0 {
+		return diff, nil
+	}
+
+	logrus.Infof("Fetching %d tags only found in %s", len(diff.RemoteOnly), displayRemote(gp.remote()))
+	args := []string{"fetch", "--no-tags", gp.remote()}
+	for _, tag := range diff.RemoteOnly {
+		args = append(args, gp.remoteRefName(gp.tagRef(tag))+":"+gp.tagRef(tag))
+	}
+	if _, err := gp.runRemoteGit(gp.remote(), args...); err != nil {
+		return nil, errors.Wrapf(
+			maskCredentials(err), "fetching tags from %s", displayRemote(gp.remote()),
+		)
+	}
+	return diff, nil
+}
+
+// ErrPushSetMismatch is returned by AssertPushSet when the tags pending to be
+// pushed differ from the expected ones
+var ErrPushSetMismatch = errors.New("tags pending to be pushed differ from the expected ones")
+
+// AssertPushSet verifies that the local tags missing in the remote, which a
+// bulk push of the local tags would push, are exactly the expected ones. It
+// is meant as a safety gate before mirroring, so that unintended tags are
+// never leaked. On mismatch the unexpected and the missing tags are listed.
+func (gp *GitObjectPusher) AssertPushSet(expected []string) error {
+	diff, err := gp.DiffTags(false)
+	if err != nil {
+		return errors.Wrap(err, "computing tags pending to be pushed")
+	}
+
+	pending := map[string]bool{}
+	for _, tag := range diff.LocalOnly {
+		pending[tag] = true
+	}
+	allowed := map[string]bool{}
+	missing := []string{}
+	for _, tag := range expected {
+		allowed[tag] = true
+		if !pending[tag] {
+			missing = append(missing, tag)
+		}
+	}
+	unexpected := []string{}
+	for _, tag := range diff.LocalOnly {
+		if !allowed[tag] {
+			unexpected = append(unexpected, tag)
+		}
+	}
+	if len(unexpected) == 0 && len(missing) == 0 {
+		logrus.Infof("The %d tags pending to be pushed are the expected ones", len(pending))
+		return nil
+	}
+
+	sort.Strings(missing)
+	problems := []string{}
+	if len(unexpected) > 0 {
+		problems = append(problems, "unexpected tags: "+strings.Join(unexpected, ", "))
+	}
+	if len(missing) > 0 {
+		problems = append(problems, "missing tags: "+strings.Join(missing, ", "))
+	}
+	return errors.Wrap(ErrPushSetMismatch, strings.Join(problems, "; "))
+}
+
+// RefChange is a ref which differs between two remote ref snapshots
+type RefChange struct {
+	// Full name of the ref, eg refs/tags/v1.20.0
+	Ref string
+
+	// SHA the ref pointed to before, empty if it was created
+	OldSHA string
+
+	// SHA the ref points to after, empty if it was deleted
+	NewSHA string
+}
+
+// String returns a human readable representation of the change
+func (c RefChange) String() string {
+	switch {
+	case c.OldSHA == "":
+		return fmt.Sprintf("%s created at %s", c.Ref, c.NewSHA)
+	case c.NewSHA == "":
+		return fmt.Sprintf("%s deleted, was at %s", c.Ref, c.OldSHA)
+	default:
+		return fmt.Sprintf("%s updated from %s to %s", c.Ref, c.OldSHA, c.NewSHA)
+	}
+}
+
+// SnapshotRemoteRefs records the branches and tags in the remote mapped to
+// the SHA they point to, eg before and after a push session to keep an
+// audit trail of what it changed. Compare snapshots with DiffRefSnapshots.
+func (gp *GitObjectPusher) SnapshotRemoteRefs() (map[string]string, error) {
+	refs, err := gp.remoteRefs(gp.remote())
+	if err != nil {
+		return nil, errors.Wrap(err, "listing remote references")
+	}
+
+	// The peeled refs of annotated tags are derived, not refs of their own
+	snapshot := map[string]string{}
+	for ref, sha := range refs {
+		if !strings.HasSuffix(ref, peeledRefSuffix) {
+			snapshot[ref] = sha
+		}
+	}
+	logrus.Infof("Recorded %d refs of %s", len(snapshot), displayRemote(gp.remote()))
+	return snapshot, nil
+}
+
+// DiffRefSnapshots returns the refs created, deleted or moved between two
+// snapshots taken by SnapshotRemoteRefs, sorted by ref name
+func DiffRefSnapshots(before, after map[string]string) []RefChange {
+	changes := []RefChange{}
+	for ref, oldSHA := range before {
+		if newSHA := after[ref]; newSHA != oldSHA {
+			changes = append(changes, RefChange{Ref: ref, OldSHA: oldSHA, NewSHA: newSHA})
+		}
+	}
+	for ref, newSHA := range after {
+		if _, ok := before[ref]; !ok {
+			changes = append(changes, RefChange{Ref: ref, NewSHA: newSHA})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Ref < changes[j].Ref
+	})
+	return changes
+}
@@ -0,0 +1,111 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package release
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// bundleRefPrefix is the local namespace the refs of a bundle are fetched
+// into while pushing them
+const bundleRefPrefix = "refs/release-bundle/"
+
+// PushBundle pushes the release branches and tags contained in a git bundle
+// to the remote, eg for air-gapped transfers where only the bundle is moved
+// between networks. The bundle is verified against the local repository,
+// which has to contain its prerequisite commits, and refused as a whole if
+// it contains any ref which is not a valid release branch or tag.
+func (gp *GitObjectPusher) PushBundle(bundlePath, remote string) error {
+	if err := gp.checkFrozen(); err != nil {
+		return err
+	}
+
+	if _, err := gp.runGit("bundle", "verify", bundlePath); err != nil {
+		return errors.Wrapf(err, "verifying bundle %s", bundlePath)
+	}
+	heads, err := gp.runGit("bundle", "list-heads", bundlePath)
+	if err != nil {
+		return errors.Wrapf(err, "listing refs of bundle %s", bundlePath)
+	}
+
+	refs := []string{}
+	refused := []string{}
+	for _, line := range strings.Split(heads, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		ref := fields[1]
+		refs = append(refs, ref)
+		if err := gp.checkBundleRef(ref); err != nil {
+			refused = append(refused, fmt.Sprintf("%s: %v", ref, err))
+		}
+	}
+	if len(refused) > 0 {
+		return errors.Errorf(
+			"refusing bundle %s with %d unexpected refs: %s",
+			bundlePath, len(refused), strings.Join(refused, "; "),
+		)
+	}
+	if len(refs) == 0 {
+		return errors.Errorf("bundle %s contains no refs", bundlePath)
+	}
+
+	// The objects are fetched into the local repository under a separate
+	// namespace to not touch the local branches and tags
+	args := []string{"fetch", "--no-tags", bundlePath}
+	for _, ref := range refs {
+		args = append(args, "+"+ref+":"+bundleRefPrefix+ref)
+	}
+	if _, err := gp.runGit(args...); err != nil {
+		return errors.Wrapf(err, "fetching refs of bundle %s", bundlePath)
+	}
+	defer func() {
+		for _, ref := range refs {
+			if _, err := gp.runGit("update-ref", "-d", bundleRefPrefix+ref); err != nil {
+				logrus.Warnf("Unable to remove temporary ref %s: %v", bundleRefPrefix+ref, err)
+			}
+		}
+	}()
+
+	for _, ref := range refs {
+		logrus.Infof(
+			"Pushing%s %s from bundle to %s", dryRunLabel[gp.opts.DryRun], ref, displayRemote(remote),
+		)
+		if err := gp.pushRef(remote, bundleRefPrefix+ref+":"+ref); err != nil {
+			return errors.Wrapf(err, "pushing %s from bundle", ref)
+		}
+	}
+	logrus.Infof("Pushed %d refs from bundle %s", len(refs), bundlePath)
+	return nil
+}
+
+// checkBundleRef verifies that a ref contained in a bundle is a valid
+// release branch or tag
+func (gp *GitObjectPusher) checkBundleRef(ref string) error {
+	switch {
+	case strings.HasPrefix(ref, branchRefPrefix):
+		return gp.checkBranchName(strings.TrimPrefix(ref, branchRefPrefix))
+	case strings.HasPrefix(ref, gp.tagNamespace()):
+		return gp.checkTagName(strings.TrimPrefix(ref, gp.tagNamespace()))
+	}
+	return errors.New("only release branches and tags can be pushed")
+}
@@ -0,0 +1,88 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package release
+
+import (
+	"io/ioutil"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"k8s.io/release/pkg/util"
+)
+
+// defaultChangelogTagMessageTemplate is used to render the annotation of
+// created tags when a ChangelogFile but no TagMessageTemplate is set
+const defaultChangelogTagMessageTemplate = defaultTagMessageTemplate + "\n\n{{ .Changelog }}"
+
+// markdownHeadingRegex matches a markdown heading, capturing its level
+// markers and its text
+var markdownHeadingRegex = regexp.MustCompile(`^(#+)\s+(.*)$`)
+
+// ExtractChangelogSection returns the body of the section of a markdown
+// changelog about a version tag. The section is the one whose heading
+// mentions the tag, with or without the util.TagPrefix, eg "# v1.20.0",
+// "## [1.20.0] - 2020-12-08" or "## Changelog since v1.19.0" not matching
+// v1.19.0. It ends at the next heading of the same or a higher level.
+func ExtractChangelogSection(changelog, tagName string) (string, error) {
+	version := regexp.QuoteMeta(util.TrimTagPrefix(tagName))
+	headingRegex := regexp.MustCompile(
+		`^\[?` + regexp.QuoteMeta(util.TagPrefix) + `?` + version + `\]?(\s|$)`,
+	)
+
+	lines := strings.Split(changelog, "\n")
+	for i, line := range lines {
+		match := markdownHeadingRegex.FindStringSubmatch(strings.TrimRight(line, "\r"))
+		if match == nil || !headingRegex.MatchString(match[2]) {
+			continue
+		}
+
+		level := len(match[1])
+		end := len(lines)
+		for j := i + 1; j < len(lines); j++ {
+			next := markdownHeadingRegex.FindStringSubmatch(lines[j])
+			if next != nil && len(next[1]) <= level {
+				end = j
+				break
+			}
+		}
+		section := strings.TrimSpace(strings.Join(lines[i+1:end], "\n"))
+		if section == "" {
+			return "", errors.Errorf("changelog section of %s is empty", tagName)
+		}
+		return section, nil
+	}
+	return "", errors.Errorf("changelog has no section for %s", tagName)
+}
+
+// changelogSection reads the section about a tag from the ChangelogFile
+func (gp *GitObjectPusher) changelogSection(tagName string) (string, error) {
+	content, err := ioutil.ReadFile(gp.opts.ChangelogFile)
+	if err != nil {
+		return "", errors.Wrap(err, "reading changelog")
+	}
+	extract := gp.opts.ChangelogExtractor
+	if extract == nil {
+		extract = ExtractChangelogSection
+	}
+	section, err := extract(string(content), tagName)
+	if err != nil {
+		return "", errors.Wrapf(err, "extracting changelog section from %s", gp.opts.ChangelogFile)
+	}
+	return section, nil
+}
@@ -0,0 +1,189 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package release
+
+import (
+	"encoding/base64"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"k8s.io/release/pkg/git"
+)
+
+const (
+	// defaultSSHCommand is the ssh command extended with the per remote keys
+	// when no SSHCommand is set
+	defaultSSHCommand = "ssh"
+
+	// defaultTokenUsername is the user name sent with the tokens, the one
+	// GitHub expects for app and personal access tokens
+	defaultTokenUsername = "x-access-token"
+)
+
+// RemoteCredential holds the credentials used to push to a single remote
+type RemoteCredential struct {
+	// Token used to authenticate to https remotes, eg a GitHub token
+	Token string
+
+	// User name sent with the Token, eg "oauth2" for GitLab. Defaults to
+	// "x-access-token" as expected by GitHub.
+	Username string
+
+	// Path of the private key used to authenticate to ssh remotes
+	SSHKey string
+}
+
+// validateRemoteCredentials checks that every remote credential has exactly
+// one authentication method set, and that the remotes they are indexed by are
+// configured in the repository, to catch typos before a push silently falls
+// back to the default authentication. URLs are not checked.
+func validateRemoteCredentials(repo *git.Repo, credentials map[string]RemoteCredential) error {
+	names := []string{}
+	for remote, credential := range credentials {
+		if (credential.Token == "") == (credential.SSHKey == "") {
+			return errors.Errorf(
+				"credentials of remote %s need either a token or an ssh key",
+				displayRemote(remote),
+			)
+		}
+		if credential.Username != "" && credential.Token == "" {
+			return errors.Errorf(
+				"credentials of remote %s have a user name but no token", displayRemote(remote),
+			)
+		}
+		if !strings.ContainsAny(remote, ":/") {
+			names = append(names, remote)
+		}
+	}
+	if len(names) == 0 {
+		return nil
+	}
+
+	remotes, err := configuredRemotes(repo)
+	if err != nil {
+		return err
+	}
+	configured := map[string]bool{}
+	for _, remote := range remotes {
+		configured[remote] = true
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if !configured[name] {
+			return errors.Errorf(
+				"remote %s is not configured in the repository, known remotes: %s",
+				name, strings.Join(remotes, ", "),
+			)
+		}
+	}
+	return nil
+}
+
+// pushEnv returns the environment variables for pushing to a remote. On top
+// of the ones of remoteEnv, it sets the SSHCommand, which only applies to the
+// push invocations.
+func (gp *GitObjectPusher) pushEnv(remote string) []string {
+	return gp.gitRemoteEnv(remote, gp.opts.SSHCommand)
+}
+
+// remoteEnv returns the environment variables for the git commands contacting
+// a remote, which set the credentials and the ssh settings configured for it.
+// The values are passed in the environment to keep them out of the command
+// line.
+func (gp *GitObjectPusher) remoteEnv(remote string) []string {
+	return gp.gitRemoteEnv(remote, "")
+}
+
+// gitRemoteEnv returns the environment variables for contacting a remote
+// with the ssh command, extended with the per remote key and the ssh
+// arguments. The default one is used if the ssh command is empty.
+func (gp *GitObjectPusher) gitRemoteEnv(remote, sshCommand string) []string {
+	env := []string{}
+	credential, ok := gp.opts.RemoteCredentials[remote]
+	if ok {
+		logrus.Debugf("Using the credentials configured for %s", displayRemote(remote))
+	}
+
+	if credential.SSHKey != "" {
+		if sshCommand == "" {
+			sshCommand = defaultSSHCommand
+		}
+		sshCommand += fmt.Sprintf(" -i %s -o IdentitiesOnly=yes", shellQuote(credential.SSHKey))
+	}
+	if sshArgs := gp.sshArgs(); sshArgs != "" {
+		if sshCommand == "" {
+			sshCommand = defaultSSHCommand
+		}
+		sshCommand += sshArgs
+	}
+	if sshCommand != "" {
+		env = append(env, "GIT_SSH_COMMAND="+sshCommand)
+	}
+
+	if credential.Token != "" {
+		// The header is added after the configuration the caller may pass
+		// in the environment, which is kept
+		index := 0
+		if count, err := strconv.Atoi(os.Getenv("GIT_CONFIG_COUNT")); err == nil && count > 0 {
+			index = count
+		}
+		env = append(env,
+			fmt.Sprintf("GIT_CONFIG_COUNT=%d", index+1),
+			fmt.Sprintf("GIT_CONFIG_KEY_%d=http.extraHeader", index),
+			fmt.Sprintf("GIT_CONFIG_VALUE_%d=Authorization: Basic %s", index, credential.basicAuth()),
+		)
+	}
+	return env
+}
+
+// secrets returns the sensitive values which must never appear in the logs
+func (gp *GitObjectPusher) secrets() []string {
+	secrets := []string{}
+	if gp.opts.SSHCommand != "" {
+		secrets = append(secrets, gp.opts.SSHCommand)
+	}
+	for _, credential := range gp.opts.RemoteCredentials {
+		if credential.Token != "" {
+			secrets = append(secrets, credential.Token, credential.basicAuth())
+		}
+	}
+	return secrets
+}
+
+// maskSecrets replaces the secrets in a string
+func (gp *GitObjectPusher) maskSecrets(s string) string {
+	for _, secret := range gp.secrets() {
+		s = strings.ReplaceAll(s, secret, "[REDACTED]")
+	}
+	return s
+}
+
+// basicAuth encodes the user name and token for the http basic
+// authentication header
+func (c RemoteCredential) basicAuth() string {
+	username := c.Username
+	if username == "" {
+		username = defaultTokenUsername
+	}
+	return base64.StdEncoding.EncodeToString([]byte(username + ":" + c.Token))
+}
@@ -0,0 +1,217 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package release
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// tagExpiryNotesRef stores the expiration times of the ephemeral tags as
+	// git notes on the tag objects
+	tagExpiryNotesRef = "refs/notes/release-expiry"
+
+	// tagExpiryPrefix starts the expiration time in the notes, which is
+	// formatted as RFC 3339 in UTC, eg "expires: 2020-11-02T15:04:05Z"
+	tagExpiryPrefix = "expires: "
+)
+
+// PushTagWithExpiry pushes a tag like PushTag and marks it as expiring after
+// ttl with a note in refs/notes/release-expiry, which is pushed too. Expired
+// tags are deleted from the remote by PruneExpiredTags, eg from a cleanup
+// job for the tags of ephemeral preview environments. In dry-run mode the
+// expiry is neither stored nor pushed.
+func (gp *GitObjectPusher) PushTagWithExpiry(tagName string, ttl time.Duration) error {
+	if err := gp.checkFrozen(); err != nil {
+		return err
+	}
+	if ttl <= 0 {
+		return errors.Errorf("tag expiry has to be positive, got %s", ttl)
+	}
+	if err := gp.PushTag(tagName); err != nil {
+		return err
+	}
+
+	sha, err := gp.runGit("rev-parse", gp.tagRef(tagName))
+	if err != nil {
+		return errors.Wrapf(err, "resolving tag %s", tagName)
+	}
+	expiry := gp.clock().Now().Add(ttl).UTC()
+	if gp.opts.DryRun {
+		logrus.Infof(
+			"Skipping expiry of tag %s at %s in dry-run mode", tagName, expiry.Format(time.RFC3339),
+		)
+		return nil
+	}
+	if _, err := gp.fetchExpiryNotes(); err != nil {
+		return err
+	}
+
+	logrus.Infof("Marking tag %s as expiring at %s", tagName, expiry.Format(time.RFC3339))
+	if _, err := gp.runGit(append(
+		gp.identityConfig(), "notes", "--ref", tagExpiryNotesRef, "add", "--force",
+		"--message", tagExpiryPrefix+expiry.Format(time.RFC3339), sha,
+	)...); err != nil {
+		return errors.Wrapf(err, "storing expiry of tag %s", tagName)
+	}
+	if err := gp.pushRef(gp.remote(), tagExpiryNotesRef); err != nil {
+		return errors.Wrapf(err, "pushing expiry of tag %s", tagName)
+	}
+	return nil
+}
+
+// PruneExpiredTags deletes the tags from the remote whose expiry set by
+// PushTagWithExpiry is before now, together with their expiry notes. Tags
+// without an expiry are never deleted. The errors of the individual
+// deletions are returned together. In dry-run mode the deletions are only
+// simulated.
+func (gp *GitObjectPusher) PruneExpiredTags(now time.Time) error {
+	if err := gp.checkFrozen(); err != nil {
+		return err
+	}
+
+	found, err := gp.fetchExpiryNotes()
+	if err != nil {
+		return err
+	}
+	if !found {
+		logrus.Infof("No tags with an expiry found in %s", displayRemote(gp.remote()))
+		return nil
+	}
+
+	refs, err := gp.remoteRefs(gp.remote())
+	if err != nil {
+		return errors.Wrap(err, "listing remote references")
+	}
+	tagsByObject := map[string][]string{}
+	for ref, sha := range refs {
+		if strings.HasPrefix(ref, gp.tagNamespace()) && !strings.HasSuffix(ref, peeledRefSuffix) {
+			tagsByObject[sha] = append(tagsByObject[sha], strings.TrimPrefix(ref, gp.tagNamespace()))
+		}
+	}
+
+	expired, err := gp.expiredObjects(now)
+	if err != nil {
+		return err
+	}
+
+	failed := []string{}
+	pruned := []string{}
+	for _, sha := range expired {
+		tags := tagsByObject[sha]
+		sort.Strings(tags)
+		deleted := true
+		for _, tag := range tags {
+			logrus.Infof("Deleting%s expired tag %s", dryRunLabel[gp.opts.DryRun], tag)
+			if err := gp.pushRef(gp.remote(), ":"+gp.tagRef(tag)); err != nil {
+				logrus.Errorf("Deleting expired tag %s failed: %v", tag, err)
+				failed = append(failed, fmt.Sprintf("%s: %v", tag, err))
+				deleted = false
+				continue
+			}
+			pruned = append(pruned, tag)
+		}
+		// Notes of the tags which could not be deleted are kept to retry
+		if !deleted || gp.opts.DryRun {
+			continue
+		}
+		if _, err := gp.runGit(append(
+			gp.identityConfig(), "notes", "--ref", tagExpiryNotesRef, "remove", sha,
+		)...); err != nil {
+			return errors.Wrapf(err, "removing expiry of object %s", sha)
+		}
+	}
+
+	if len(expired) > 0 && !gp.opts.DryRun {
+		if err := gp.pushRef(gp.remote(), tagExpiryNotesRef); err != nil {
+			return errors.Wrap(err, "pushing pruned tag expiries")
+		}
+	}
+	if len(failed) > 0 {
+		return errors.Errorf(
+			"deleting %d expired tags failed: %s", len(failed), strings.Join(failed, "; "),
+		)
+	}
+	logrus.Infof(
+		"Pruned%s %d expired tags from %s",
+		dryRunLabel[gp.opts.DryRun], len(pruned), displayRemote(gp.remote()),
+	)
+	return nil
+}
+
+// expiredObjects returns the SHAs of the objects whose expiry note is
+// before now, sorted
+func (gp *GitObjectPusher) expiredObjects(now time.Time) ([]string, error) {
+	output, err := gp.runGit("notes", "--ref", tagExpiryNotesRef, "list")
+	if err != nil {
+		return nil, errors.Wrap(err, "listing tag expiries")
+	}
+
+	expired := []string{}
+	for _, line := range strings.Split(output, "\n") {
+		// Each line lists the note blob and the annotated object
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		note, err := gp.runGit("notes", "--ref", tagExpiryNotesRef, "show", fields[1])
+		if err != nil {
+			return nil, errors.Wrapf(err, "reading expiry of object %s", fields[1])
+		}
+		expiry, err := time.Parse(time.RFC3339, strings.TrimPrefix(strings.TrimSpace(note), tagExpiryPrefix))
+		if err != nil {
+			if warnErr := gp.warn("Ignoring invalid expiry of object %s: %v", fields[1], err); warnErr != nil {
+				return nil, warnErr
+			}
+			continue
+		}
+		if expiry.Before(now) {
+			expired = append(expired, fields[1])
+		}
+	}
+	sort.Strings(expired)
+	return expired, nil
+}
+
+// fetchExpiryNotes updates the local expiry notes with the ones in the
+// remote, which are authoritative. It returns false if the remote has none.
+func (gp *GitObjectPusher) fetchExpiryNotes() (bool, error) {
+	output, err := gp.lsRemote(gp.remote(), tagExpiryNotesRef)
+	if err != nil {
+		return false, errors.Wrapf(
+			maskCredentials(err), "looking up tag expiries in %s", displayRemote(gp.remote()),
+		)
+	}
+	if _, found := parseLsRemote(output)[tagExpiryNotesRef]; !found {
+		return false, nil
+	}
+	if _, err := gp.runRemoteGit(
+		gp.remote(), "fetch", "--no-tags", gp.remote(),
+		"+"+gp.remoteRefName(tagExpiryNotesRef)+":"+tagExpiryNotesRef,
+	); err != nil {
+		return false, errors.Wrapf(
+			maskCredentials(err), "fetching tag expiries from %s", displayRemote(gp.remote()),
+		)
+	}
+	return true, nil
+}
@@ -0,0 +1,70 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package release_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"k8s.io/release/pkg/release"
+	"k8s.io/release/pkg/release/internal/pushtest"
+)
+
+func TestGitObjectPusherFlows(t *testing.T) {
+	repo := pushtest.NewRepo(t)
+	repo.Git(t, "branch", "release-1.20")
+	repo.Git(t, "tag", "v1.20.0")
+
+	// Dry runs leave the remote untouched
+	dryRun, err := release.NewGitPusher(&release.GitObjectPusherOptions{
+		RepoPath: repo.Path, DryRun: true,
+	})
+	require.Nil(t, err)
+	require.Nil(t, dryRun.PushBranches([]string{"release-1.20"}))
+	require.Nil(t, dryRun.PushTags([]string{"v1.20.0"}))
+	require.Empty(t, repo.RemoteRefs(t))
+
+	pusher, err := release.NewGitPusher(&release.GitObjectPusherOptions{
+		RepoPath: repo.Path, DeletableTagPatterns: []string{`^v1\.20\.0$`},
+	})
+	require.Nil(t, err)
+
+	// Push
+	require.Nil(t, pusher.PushBranches([]string{"release-1.20"}))
+	require.Nil(t, pusher.PushTags([]string{"v1.20.0"}))
+	repo.RequireRemoteRef(t, "refs/heads/release-1.20")
+	repo.RequireRemoteRef(t, "refs/tags/v1.20.0")
+
+	// Skip the objects already in the remote
+	require.Nil(t, pusher.PushTags([]string{"v1.20.0"}))
+	outcomes := []release.PushOutcome{}
+	for _, record := range pusher.Report().Records {
+		if record.Name == "v1.20.0" {
+			outcomes = append(outcomes, record.Outcome)
+		}
+	}
+	require.ElementsMatch(t, []release.PushOutcome{
+		release.PushOutcomePushed, release.PushOutcomeSkipped,
+	}, outcomes)
+
+	// Delete, which is simulated in dry-run mode
+	require.Nil(t, dryRun.DeleteRemoteTag("v1.20.0"))
+	repo.RequireRemoteRef(t, "refs/tags/v1.20.0")
+	require.Nil(t, pusher.DeleteRemoteTags([]string{"v1.20.0"}))
+	repo.RequireNoRemoteRef(t, "refs/tags/v1.20.0")
+	require.Nil(t, pusher.DeleteRemoteTag("v1.20.0"))
+}
@@ -0,0 +1,122 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package release
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// namespaceRefPrefix is prepended to every component of a git namespace to
+// get the ref prefix of the namespace
+const namespaceRefPrefix = "refs/namespaces/"
+
+// namespaceComponentRegex matches a valid component of a remote namespace
+var namespaceComponentRegex = regexp.MustCompile(`^[a-zA-Z0-9_][a-zA-Z0-9_.-]*$`)
+
+// validateRemoteNamespace checks that a remote namespace is a slash separated
+// path of valid ref components. Malformed namespaces, like "/org/team/" or
+// "refs/namespaces/org", would push the refs to somewhere else than intended.
+func validateRemoteNamespace(namespace string) error {
+	if namespace == "" {
+		return nil
+	}
+	for _, component := range strings.Split(namespace, "/") {
+		if !namespaceComponentRegex.MatchString(component) ||
+			strings.HasSuffix(component, ".lock") || strings.Contains(component, "..") ||
+			component == "refs" {
+			return errors.Errorf(
+				"invalid remote namespace %q, expected a path like org/team", namespace,
+			)
+		}
+	}
+	return nil
+}
+
+// remoteNamespacePrefix returns the prefix of the refs in the remote
+// namespace, eg refs/namespaces/org/refs/namespaces/team/ for "org/team", or
+// an empty string if no namespace is set
+func (gp *GitObjectPusher) remoteNamespacePrefix() string {
+	if gp.opts.RemoteNamespace == "" {
+		return ""
+	}
+	var sb strings.Builder
+	for _, component := range strings.Split(gp.opts.RemoteNamespace, "/") {
+		sb.WriteString(namespaceRefPrefix + component + "/")
+	}
+	return sb.String()
+}
+
+// remoteRefName returns the name a ref has in the remote namespace
+func (gp *GitObjectPusher) remoteRefName(ref string) string {
+	return gp.remoteNamespacePrefix() + ref
+}
+
+// remoteRefspec rewrites the destination of a push refspec to the remote
+// namespace. Short names are completed to the full ref name first, as git
+// cannot infer where they go inside the namespace.
+func (gp *GitObjectPusher) remoteRefspec(refspec string) string {
+	if gp.remoteNamespacePrefix() == "" {
+		return refspec
+	}
+
+	force := ""
+	if strings.HasPrefix(refspec, "+") {
+		force, refspec = "+", strings.TrimPrefix(refspec, "+")
+	}
+	src, dst := refspec, refspec
+	if i := strings.Index(refspec, ":"); i >= 0 {
+		src, dst = refspec[:i], refspec[i+1:]
+	}
+	if !strings.HasPrefix(dst, "refs/") {
+		if objType, name := gp.refObject(dst); objType == PushKindTag {
+			dst = gp.tagRef(name)
+		} else {
+			dst = branchRefPrefix + name
+		}
+	}
+	return force + src + ":" + gp.remoteRefName(dst)
+}
+
+// lsRemote lists the refs of a remote matching the patterns, which have to
+// be full ref names or globs of them. Inside a remote namespace the patterns
+// are looked up in the namespace and the listed refs are stripped of its
+// prefix, so callers see the same output as without it. The remote is
+// contacted with the credentials configured for it.
+func (gp *GitObjectPusher) lsRemote(remote string, patterns ...string) (string, error) {
+	prefix := gp.remoteNamespacePrefix()
+	args := []string{"ls-remote", remote}
+	for _, pattern := range patterns {
+		args = append(args, prefix+pattern)
+	}
+	output, err := gp.runRemoteGit(remote, args...)
+	if err != nil || prefix == "" {
+		return output, err
+	}
+
+	lines := []string{}
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 || !strings.HasPrefix(fields[1], prefix) {
+			continue
+		}
+		lines = append(lines, fields[0]+"\t"+strings.TrimPrefix(fields[1], prefix))
+	}
+	return strings.Join(lines, "\n"), nil
+}
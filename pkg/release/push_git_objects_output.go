@@ -0,0 +1,80 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package release
+
+import (
+	"bytes"
+
+	"github.com/sirupsen/logrus"
+	"k8s.io/release/pkg/command"
+)
+
+// runStreamed runs a git command silently, streaming its output to the
+// OutputWriter if set
+func (gp *GitObjectPusher) runStreamed(cmd *command.Command) (*command.Status, error) {
+	if gp.opts.OutputWriter == nil {
+		return cmd.RunSilent()
+	}
+
+	// The standard output and error are copied concurrently, so each one
+	// gets its own buffer
+	stdout := &maskedOutputWriter{gp: gp}
+	stderr := &maskedOutputWriter{gp: gp}
+	status, err := cmd.AddOutputWriter(stdout).AddErrorWriter(stderr).RunSilentWithWriters()
+	stdout.flush()
+	stderr.flush()
+	return status, err
+}
+
+// maskedOutputWriter forwards the output of a command to the OutputWriter
+// with the credentials masked. The output is forwarded on every line break
+// or carriage return, which git uses to update the progress in place, so
+// that secrets cannot be split across writes.
+type maskedOutputWriter struct {
+	gp  *GitObjectPusher
+	buf []byte
+}
+
+// Write buffers the output and forwards the complete lines
+func (w *maskedOutputWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	if end := bytes.LastIndexAny(w.buf, "\r\n"); end >= 0 {
+		w.forward(w.buf[:end+1])
+		w.buf = append([]byte{}, w.buf[end+1:]...)
+	}
+	return len(p), nil
+}
+
+// flush forwards the remaining output without a trailing line break
+func (w *maskedOutputWriter) flush() {
+	if len(w.buf) > 0 {
+		w.forward(w.buf)
+		w.buf = nil
+	}
+}
+
+// forward writes the masked output to the OutputWriter. Failures to write
+// are only logged, they must not fail the push.
+func (w *maskedOutputWriter) forward(output []byte) {
+	w.gp.outputMtx.Lock()
+	defer w.gp.outputMtx.Unlock()
+	if _, err := w.gp.opts.OutputWriter.Write(
+		[]byte(w.gp.maskPushOutput(string(output))),
+	); err != nil {
+		logrus.Debugf("Unable to write the git output: %v", err)
+	}
+}
@@ -0,0 +1,460 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package release
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// PlanAction is what pushing an object would do to the remote
+type PlanAction string
+
+const (
+	// PlanCreate means the object does not exist in the remote yet
+	PlanCreate PlanAction = "create"
+
+	// PlanUpdate means the remote branch points to a different commit and
+	// would be moved to the local one
+	PlanUpdate PlanAction = "update"
+
+	// PlanSkip means the object is already present in the remote and would
+	// be left untouched
+	PlanSkip PlanAction = "skip"
+)
+
+// PushPlan lists what pushing a set of objects would change in the remote
+type PushPlan struct {
+	// Remote the plan was computed for, with credentials masked
+	Remote string
+
+	// Planned objects in the order they would be pushed
+	Items []PlanItem
+}
+
+// PlanItem is the planned action for a single object
+type PlanItem struct {
+	// Kind of the object, PushKindBranch or PushKindTag
+	Kind string
+
+	// Name of the branch or tag
+	Name string
+
+	// What pushing the object would do
+	Action PlanAction
+
+	// Commit the local object points to
+	LocalCommit string
+
+	// Commit the object points to in the remote, empty if it does not exist
+	RemoteCommit string
+}
+
+// Filter returns the planned items with the specified action
+func (p *PushPlan) Filter(action PlanAction) []PlanItem {
+	items := []PlanItem{}
+	for _, item := range p.Items {
+		if item.Action == action {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// String returns a human readable representation of the plan, with the
+// objects to be created, updated and skipped listed separately
+func (p *PushPlan) String() string {
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("Push plan for %s:\n", p.Remote))
+	for _, action := range []PlanAction{PlanCreate, PlanUpdate, PlanSkip} {
+		items := p.Filter(action)
+		sb.WriteString(fmt.Sprintf("%s (%d):\n", action, len(items)))
+		for _, item := range items {
+			sb.WriteString(fmt.Sprintf("- %s %s at %s", item.Kind, item.Name, item.LocalCommit))
+			if item.RemoteCommit != "" && item.RemoteCommit != item.LocalCommit {
+				sb.WriteString(fmt.Sprintf(" (remote at %s)", item.RemoteCommit))
+			}
+			sb.WriteString("\n")
+		}
+	}
+	return sb.String()
+}
+
+// ErrPlanDrift is returned by Apply when the local or remote objects changed
+// since the plan was computed
+var ErrPlanDrift = errors.New("objects changed since the plan was computed")
+
+// Plan computes what pushing the branches and then the tags would change in
+// the remote without pushing anything. The plan can be reviewed and then
+// pushed with Apply.
+func (gp *GitObjectPusher) Plan(branchList, tagList []string) (*PushPlan, error) {
+	return gp.plan(branchList, tagList)
+}
+
+// Apply pushes the objects a plan creates or updates. The plan is computed
+// again first and Apply fails with ErrPlanDrift if any local or remote
+// commit differs from the reviewed plan, unless ForceApply is set.
+func (gp *GitObjectPusher) Apply(plan *PushPlan) error {
+	if err := gp.checkFrozen(); err != nil {
+		return err
+	}
+	if plan.Remote != displayRemote(gp.remote()) {
+		return errors.Errorf(
+			"plan was computed for %s, not %s", plan.Remote, displayRemote(gp.remote()),
+		)
+	}
+
+	branchList, tagList := []string{}, []string{}
+	for _, item := range plan.Items {
+		if item.Kind == PushKindBranch {
+			branchList = append(branchList, item.Name)
+		} else {
+			tagList = append(tagList, item.Name)
+		}
+	}
+	current, err := gp.plan(branchList, tagList)
+	if err != nil {
+		return errors.Wrap(err, "computing current plan")
+	}
+
+	drift := planDrift(plan, current)
+	if len(drift) > 0 {
+		if !gp.opts.ForceApply {
+			return errors.Wrapf(ErrPlanDrift, "applying plan: %s", strings.Join(drift, ", "))
+		}
+		logrus.Warnf("Forcing plan despite changed objects: %s", strings.Join(drift, ", "))
+	}
+
+	pushBranches, pushTags := []string{}, []string{}
+	for _, item := range plan.Items {
+		if item.Action == PlanSkip {
+			continue
+		}
+		if item.Kind == PushKindBranch {
+			pushBranches = append(pushBranches, item.Name)
+		} else {
+			pushTags = append(pushTags, item.Name)
+		}
+	}
+	if err := gp.PushBranches(pushBranches); err != nil {
+		return errors.Wrap(err, "applying plan")
+	}
+	if err := gp.PushTags(pushTags); err != nil {
+		return errors.Wrap(err, "applying plan")
+	}
+	return nil
+}
+
+// planDrift describes the items of a plan whose commits differ in the
+// current plan of the same objects
+func planDrift(plan, current *PushPlan) []string {
+	currentItems := map[string]PlanItem{}
+	for _, item := range current.Items {
+		currentItems[item.Kind+" "+item.Name] = item
+	}
+
+	drift := []string{}
+	for _, item := range plan.Items {
+		now := currentItems[item.Kind+" "+item.Name]
+		if item.LocalCommit != now.LocalCommit {
+			drift = append(drift, fmt.Sprintf(
+				"local %s %s moved from %s to %s",
+				item.Kind, item.Name, item.LocalCommit, now.LocalCommit,
+			))
+		}
+		if item.RemoteCommit != now.RemoteCommit {
+			drift = append(drift, fmt.Sprintf(
+				"remote %s %s changed from %s to %s", item.Kind, item.Name,
+				planCommitLabel(item.RemoteCommit), planCommitLabel(now.RemoteCommit),
+			))
+		}
+	}
+	return drift
+}
+
+// planCommitLabel returns the commit or "missing" if the object does not
+// exist in the remote
+func planCommitLabel(commit string) string {
+	if commit == "" {
+		return "missing"
+	}
+	return commit
+}
+
+// PlanBranches computes what PushBranches would change in the remote
+// without pushing anything
+func (gp *GitObjectPusher) PlanBranches(branchList []string) (*PushPlan, error) {
+	return gp.plan(branchList, nil)
+}
+
+// PlanTags computes what PushTags would change in the remote without
+// pushing anything
+func (gp *GitObjectPusher) PlanTags(tagList []string) (*PushPlan, error) {
+	return gp.plan(nil, tagList)
+}
+
+// plan computes the push plan of a list of branches and tags from a single
+// snapshot of the pusher remote references
+func (gp *GitObjectPusher) plan(branchList, tagList []string) (*PushPlan, error) {
+	return gp.planRemote(gp.remote(), branchList, tagList)
+}
+
+// planRemote computes the push plan of a list of branches and tags from a
+// single snapshot of the references in the specified remote
+func (gp *GitObjectPusher) planRemote(remote string, branchList, tagList []string) (*PushPlan, error) {
+	refs, err := gp.remoteRefs(remote)
+	if err != nil {
+		return nil, errors.Wrap(err, "listing remote references")
+	}
+
+	plan := &PushPlan{Remote: displayRemote(remote), Items: []PlanItem{}}
+	for _, branchName := range branchList {
+		if err := gp.checkBranchName(branchName); err != nil {
+			return nil, errors.Wrapf(err, "checking branch name %s", branchName)
+		}
+		localCommit, err := gp.resolveCommit(branchRefPrefix + branchName)
+		if err != nil {
+			return nil, errors.Errorf("branch %s does not exist in the local repo", branchName)
+		}
+
+		item := PlanItem{
+			Kind:         PushKindBranch,
+			Name:         branchName,
+			Action:       PlanCreate,
+			LocalCommit:  localCommit,
+			RemoteCommit: refs[branchRefPrefix+branchName],
+		}
+		switch {
+		case item.RemoteCommit == "":
+		case item.RemoteCommit == localCommit, gp.opts.BranchUpdatePolicy == BranchUpdateSkip:
+			item.Action = PlanSkip
+		default:
+			item.Action = PlanUpdate
+		}
+		plan.Items = append(plan.Items, item)
+	}
+
+	for _, tag := range tagList {
+		if err := gp.checkTagName(tag); err != nil {
+			return nil, errors.Wrapf(err, "checking tag name %s", tag)
+		}
+		localCommit, err := gp.resolveCommit(gp.tagRef(tag))
+		if err != nil {
+			return nil, errors.Errorf("tag %s does not exist in the local repo", tag)
+		}
+
+		// Existing tags are never moved, only skipped
+		item := PlanItem{
+			Kind:        PushKindTag,
+			Name:        tag,
+			Action:      PlanCreate,
+			LocalCommit: localCommit,
+		}
+		if sha, ok := refs[gp.tagRef(tag)]; ok {
+			item.Action = PlanSkip
+			item.RemoteCommit = sha
+			if peeled, ok := refs[gp.tagRef(tag)+peeledRefSuffix]; ok {
+				item.RemoteCommit = peeled
+			}
+		}
+		plan.Items = append(plan.Items, item)
+	}
+
+	logrus.Infof(
+		"Planned %d objects for %s: %d to create, %d to update, %d already present",
+		len(plan.Items), plan.Remote, len(plan.Filter(PlanCreate)),
+		len(plan.Filter(PlanUpdate)), len(plan.Filter(PlanSkip)),
+	)
+	return plan, nil
+}
+
+// DryRunPlans returns what the batch methods (PushBranches, PushTags and
+// PushTagToGroup) would have changed in every remote since the pusher was
+// created in dry-run mode, sorted by remote. Objects planned more than once
+// are listed once, with their latest plan.
+func (gp *GitObjectPusher) DryRunPlans() []*PushPlan {
+	gp.recordsMtx.Lock()
+	defer gp.recordsMtx.Unlock()
+
+	plans := []*PushPlan{}
+	for _, plan := range gp.dryRunPlans {
+		items := make([]PlanItem, len(plan.Items))
+		copy(items, plan.Items)
+		plans = append(plans, &PushPlan{Remote: plan.Remote, Items: items})
+	}
+	sort.Slice(plans, func(i, j int) bool {
+		return plans[i].Remote < plans[j].Remote
+	})
+	return plans
+}
+
+// accountDryRun adds the items of a plan computed in dry-run mode to the
+// plan of its remote. It is safe to call from concurrent pushes.
+func (gp *GitObjectPusher) accountDryRun(plan *PushPlan) {
+	gp.recordsMtx.Lock()
+	defer gp.recordsMtx.Unlock()
+
+	if gp.dryRunPlans == nil {
+		gp.dryRunPlans = map[string]*PushPlan{}
+	}
+	accounted, ok := gp.dryRunPlans[plan.Remote]
+	if !ok {
+		accounted = &PushPlan{Remote: plan.Remote, Items: []PlanItem{}}
+		gp.dryRunPlans[plan.Remote] = accounted
+	}
+	for _, item := range plan.Items {
+		replaced := false
+		for i := range accounted.Items {
+			if accounted.Items[i].Kind == item.Kind && accounted.Items[i].Name == item.Name {
+				accounted.Items[i] = item
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			accounted.Items = append(accounted.Items, item)
+		}
+	}
+}
+
+// PlanAsScript computes what pushing the branches and then the tags would
+// change in the remote and returns it as a shell script running the
+// equivalent git commands, so that the push can be reviewed and executed
+// manually. The script contains no credentials: it pushes to the remote in
+// the REMOTE environment variable, defaulting to the planned remote without
+// its user information, and relies on the git credential configuration of
+// whoever runs it. Every object is checked against the remote before being
+// pushed, so running the script more than once is safe.
+func (gp *GitObjectPusher) PlanAsScript(branchList, tagList []string) (string, error) {
+	plan, err := gp.plan(branchList, tagList)
+	if err != nil {
+		return "", errors.Wrap(err, "computing push plan")
+	}
+
+	var sb strings.Builder
+	sb.WriteString("#!/bin/sh\n")
+	sb.WriteString(fmt.Sprintf(
+		"# Push plan for %s: %d to create, %d to update, %d already present\n",
+		plan.Remote, len(plan.Filter(PlanCreate)),
+		len(plan.Filter(PlanUpdate)), len(plan.Filter(PlanSkip)),
+	))
+	sb.WriteString("# Run it from the local repository, objects already pushed are skipped.\n")
+	sb.WriteString("set -eu\n\n")
+	sb.WriteString("if [ -z \"${REMOTE:-}\" ]; then\n")
+	sb.WriteString(fmt.Sprintf("  REMOTE=%s\n", shellQuote(scriptRemote(gp.remote()))))
+	sb.WriteString("fi\n")
+
+	for _, item := range plan.Items {
+		sb.WriteString("\n")
+		if item.Kind == PushKindBranch {
+			gp.writeBranchScript(&sb, &item)
+		} else {
+			gp.writeTagScript(&sb, &item)
+		}
+	}
+	return sb.String(), nil
+}
+
+// writeBranchScript writes the commands pushing a planned branch. The branch
+// is pushed by commit, so the script pushes exactly the reviewed commit even
+// if the local branch moves afterwards.
+func (gp *GitObjectPusher) writeBranchScript(sb *strings.Builder, item *PlanItem) {
+	ref := shellQuote(gp.remoteRefName(branchRefPrefix + item.Name))
+	switch item.Action {
+	case PlanSkip:
+		sb.WriteString(fmt.Sprintf(
+			"# branch %s: already present in the remote at %s, nothing to do\n",
+			item.Name, item.RemoteCommit,
+		))
+		return
+	case PlanCreate:
+		sb.WriteString(fmt.Sprintf("# branch %s: create at %s\n", item.Name, item.LocalCommit))
+	default:
+		sb.WriteString(fmt.Sprintf(
+			"# branch %s: update from %s to %s\n", item.Name, item.RemoteCommit, item.LocalCommit,
+		))
+	}
+
+	// Force pushes only overwrite the reviewed remote commit
+	push := fmt.Sprintf("git push \"$REMOTE\" %s:%s", item.LocalCommit, ref)
+	if item.Action == PlanUpdate && gp.opts.BranchUpdatePolicy == BranchUpdateForce {
+		push = fmt.Sprintf(
+			"git push --force-with-lease=%s \"$REMOTE\" %s:%s",
+			shellQuote(gp.remoteRefName(branchRefPrefix+item.Name)+":"+item.RemoteCommit), item.LocalCommit, ref,
+		)
+	}
+	sb.WriteString(fmt.Sprintf(
+		"if [ \"$(git ls-remote \"$REMOTE\" %s | cut -f1)\" != %s ]; then\n",
+		ref, item.LocalCommit,
+	))
+	sb.WriteString(fmt.Sprintf("  %s\n", push))
+	sb.WriteString("else\n")
+	sb.WriteString(fmt.Sprintf("  echo %s\n", shellQuote(fmt.Sprintf(
+		"Branch %s already at %s, skipping", item.Name, item.LocalCommit,
+	))))
+	sb.WriteString("fi\n")
+}
+
+// writeTagScript writes the commands pushing a planned tag. Tags are pushed
+// by name to keep their tag objects, so the script first verifies that the
+// local tag still points to the reviewed commit.
+func (gp *GitObjectPusher) writeTagScript(sb *strings.Builder, item *PlanItem) {
+	ref := gp.tagRef(item.Name)
+	if item.Action == PlanSkip {
+		sb.WriteString(fmt.Sprintf(
+			"# tag %s: already present in the remote at %s, nothing to do\n",
+			item.Name, item.RemoteCommit,
+		))
+		return
+	}
+
+	sb.WriteString(fmt.Sprintf("# tag %s: create at %s\n", item.Name, item.LocalCommit))
+	sb.WriteString(fmt.Sprintf(
+		"if [ \"$(git rev-parse %s)\" != %s ]; then\n", shellQuote(ref+"^{commit}"), item.LocalCommit,
+	))
+	sb.WriteString(fmt.Sprintf("  echo %s >&2\n", shellQuote(fmt.Sprintf(
+		"Local tag %s does not point to %s anymore", item.Name, item.LocalCommit,
+	))))
+	sb.WriteString("  exit 1\n")
+	sb.WriteString("fi\n")
+	sb.WriteString(fmt.Sprintf(
+		"if [ -z \"$(git ls-remote \"$REMOTE\" %s)\" ]; then\n", shellQuote(gp.remoteRefName(ref)),
+	))
+	sb.WriteString(fmt.Sprintf(
+		"  git push \"$REMOTE\" %s\n", shellQuote(ref+":"+gp.remoteRefName(ref)),
+	))
+	sb.WriteString("else\n")
+	sb.WriteString(fmt.Sprintf("  echo %s\n", shellQuote(fmt.Sprintf(
+		"Tag %s already exists in the remote, skipping", item.Name,
+	))))
+	sb.WriteString("fi\n")
+}
+
+// scriptRemote returns the remote without the user information of its URL,
+// so that no credentials end up in a generated script
+func scriptRemote(remote string) string {
+	return urlCredentialsRegex.ReplaceAllString(remote, "${1}")
+}
+
+// shellQuote quotes a string to be used as a single shell word
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,102 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package release
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"k8s.io/release/pkg/git"
+)
+
+// protectedBranchMessages are printed by the hosting services when a push to
+// a protected branch is rejected, in lowercase, eg "GH006: Protected branch
+// update failed" on GitHub or "You are not allowed to push code to protected
+// branches on this project" on GitLab
+var protectedBranchMessages = []string{
+	"protected branch", "not allowed to push", "prohibited by gerrit",
+}
+
+// BranchProtected reports if pushing directly to a branch of the remote is
+// forbidden, so that the caller can choose between pushing the branch and
+// opening a pull request. Release branches and the default branch can be
+// checked. The BranchProtectionChecker option is used if set.
+//
+// Otherwise the detection is best-effort: the local branch is pushed in
+// dry-run mode and the rejection, if any, classified. Git does not send the
+// update in dry-run mode, so the protections enforced by the remote when
+// receiving pushes, like the GitHub protected branches, are only detected
+// when the transport rejects the push before that. A branch reported as not
+// protected can still reject the actual push.
+func (gp *GitObjectPusher) BranchProtected(branchName string) (bool, error) {
+	// The default branch is the target of the pull request flow
+	if branchName != git.DefaultBranch {
+		if err := gp.checkBranchName(branchName); err != nil {
+			return false, errors.Wrap(err, "checking branch name")
+		}
+	}
+	if gp.opts.BranchProtectionChecker != nil {
+		protected, err := gp.opts.BranchProtectionChecker(branchName)
+		if err != nil {
+			return false, errors.Wrapf(err, "checking protection of branch %s", branchName)
+		}
+		return protected, nil
+	}
+
+	if _, err := gp.resolveCommit(branchRefPrefix + branchName); err != nil {
+		return false, errors.Errorf("branch %s does not exist in the local repo", branchName)
+	}
+	ref := branchRefPrefix + branchName
+	status, err := gp.gitCommand(
+		"push", "--dry-run", "--porcelain", gp.remote(), ref+":"+gp.remoteRefName(ref),
+	).Env(gp.pushEnv(gp.remote())...).RunSilent()
+	if err != nil {
+		return false, errors.New(gp.maskPushOutput(
+			errors.Wrap(err, "executing git push").Error(),
+		))
+	}
+	if status.Success() {
+		logrus.Infof(
+			"Dry-run push of branch %s to %s succeeded, assuming it is not protected",
+			branchName, displayRemote(gp.remote()),
+		)
+		return false, nil
+	}
+
+	output := gp.maskPushOutput(status.Output() + status.Error())
+	if isProtectedBranchRejection(output) {
+		logrus.Infof("Branch %s is protected in %s", branchName, displayRemote(gp.remote()))
+		return true, nil
+	}
+	return false, errors.Errorf(
+		"dry-run push of branch %s failed: %s", branchName, strings.TrimSpace(output),
+	)
+}
+
+// isProtectedBranchRejection returns true if the output of git push contains
+// the rejection of a protected branch update
+func isProtectedBranchRejection(output string) bool {
+	lower := strings.ToLower(output)
+	for _, message := range protectedBranchMessages {
+		if strings.Contains(lower, message) {
+			return true
+		}
+	}
+	return false
+}
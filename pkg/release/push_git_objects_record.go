@@ -0,0 +1,145 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package release
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// pushAuditRecordVersion is the version of the PushAuditRecord schema
+const pushAuditRecordVersion = 1
+
+// unsafeFileNameRegex matches the characters of ref names replaced in the
+// file names of the signed push records
+var unsafeFileNameRegex = regexp.MustCompile(`[^a-zA-Z0-9._-]`)
+
+// PushRecordSigner signs the audit records written after every successful
+// push, eg with a KMS or gpg key, so they can be verified independently of
+// the logs of the git server
+type PushRecordSigner interface {
+	// Sign returns a detached signature of the serialized record
+	Sign(payload []byte) ([]byte, error)
+}
+
+// PushAuditRecord describes a successful push. The schema is stable: fields
+// are never renamed or removed, new fields only get added together with a
+// new Version.
+type PushAuditRecord struct {
+	// Version of the schema, currently 1
+	Version int `json:"version"`
+
+	// Who pushed, the git committer identity, eg "Jane Doe <jane@example.com>"
+	Pusher string `json:"pusher"`
+
+	// What kind of object was pushed: branch, tag or ref
+	Kind string `json:"kind"`
+
+	// Name of the pushed branch or tag, or the full name of other refs
+	Name string `json:"name"`
+
+	// Refspec as passed to git push, eg +release-1.20 or :refs/tags/v1.20.0
+	Refspec string `json:"refspec"`
+
+	// Where the object was pushed to, with credentials masked
+	Remote string `json:"remote"`
+
+	// SHA of the pushed object, empty when the ref was deleted
+	SHA string `json:"sha"`
+
+	// When the push finished, in UTC
+	Time time.Time `json:"time"`
+}
+
+// SignedPushRecord is the content of the files written to PushRecordDir
+type SignedPushRecord struct {
+	// The PushAuditRecord exactly as serialized when signing it, the
+	// signature has to be verified against these bytes
+	Record json.RawMessage `json:"record"`
+
+	// Signature of Record returned by the PushRecordSigner, base64 encoded
+	Signature []byte `json:"signature"`
+}
+
+// writePushRecord writes the signed audit record of a successful push of ref
+// to remote into PushRecordDir
+func (gp *GitObjectPusher) writePushRecord(remote, ref string) error {
+	kind, name := gp.refObject(ref)
+	record := PushAuditRecord{
+		Version: pushAuditRecordVersion,
+		Kind:    kind,
+		Name:    name,
+		Refspec: ref,
+		Remote:  displayRemote(remote),
+		Time:    gp.clock().Now().UTC(),
+	}
+
+	ident, err := gp.runGit(append(gp.identityConfig(), "var", "GIT_COMMITTER_IDENT")...)
+	if err != nil {
+		return errors.Wrap(err, "reading committer identity")
+	}
+	// The identity ends with the timestamp and the timezone
+	if fields := strings.Fields(ident); len(fields) > 2 {
+		ident = strings.Join(fields[:len(fields)-2], " ")
+	}
+	record.Pusher = ident
+
+	source := strings.TrimPrefix(ref, "+")
+	if i := strings.Index(source, ":"); i >= 0 {
+		source = source[:i]
+	}
+	if source != "" {
+		sha, err := gp.runGit("rev-parse", "--verify", source)
+		if err != nil {
+			return errors.Wrapf(err, "resolving pushed object %s", source)
+		}
+		record.SHA = sha
+	}
+
+	payload, err := json.Marshal(record)
+	if err != nil {
+		return errors.Wrap(err, "serializing push record")
+	}
+	signature, err := gp.opts.PushRecordSigner.Sign(payload)
+	if err != nil {
+		return errors.Wrap(err, "signing push record")
+	}
+	// Indenting would alter the signed bytes of the record
+	content, err := json.Marshal(SignedPushRecord{Record: payload, Signature: signature})
+	if err != nil {
+		return errors.Wrap(err, "serializing signed push record")
+	}
+
+	path := filepath.Join(gp.opts.PushRecordDir, fmt.Sprintf(
+		"%d-%s-%s.json", record.Time.UnixNano(), kind,
+		unsafeFileNameRegex.ReplaceAllString(name, "_"),
+	))
+	if err := ioutil.WriteFile(path, content, os.FileMode(0o644)); err != nil {
+		return errors.Wrap(err, "writing signed push record")
+	}
+	logrus.Debugf("Wrote signed record of the push of %s to %s", name, path)
+	return nil
+}
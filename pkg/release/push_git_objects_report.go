@@ -0,0 +1,424 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package release
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// PushKindBranch labels the records of pushed branches
+	PushKindBranch = "branch"
+
+	// PushKindTag labels the records of pushed tags
+	PushKindTag = "tag"
+
+	// PushKindRef labels other pushed refs, like the tag counter-signature
+	// notes
+	PushKindRef = "ref"
+)
+
+// pushTotalRegex matches the summary line of the objects sent by git push,
+// eg "Total 3 (delta 1), reused 0 (delta 0), pack-reused 0"
+var pushTotalRegex = regexp.MustCompile(`(?m)^Total (\d+) \(delta (\d+)\)`)
+
+// pushWrittenRegex matches the final progress line of the objects written
+// by git push, eg "Writing objects: 100% (3/3), 1.20 KiB | 1.20 MiB/s, done."
+var pushWrittenRegex = regexp.MustCompile(
+	`Writing objects: 100% \(\d+/\d+\), ([\d.]+) (bytes|KiB|MiB|GiB)`,
+)
+
+// byteUnits maps the units used by git in the progress output to bytes
+var byteUnits = map[string]float64{
+	"bytes": 1, "KiB": 1 << 10, "MiB": 1 << 20, "GiB": 1 << 30,
+}
+
+// pushProgressPrefixes start the progress lines in the output of git push
+var pushProgressPrefixes = []string{
+	"Enumerating objects:", "Counting objects:", "Delta compression",
+	"Compressing objects:", "Writing objects:", "Total ",
+}
+
+// remoteMessagePrefix starts the lines in the output of git push printed by
+// the remote, eg by its hooks
+const remoteMessagePrefix = "remote:"
+
+// remoteProgressPrefixes start the progress lines printed by the remote
+var remoteProgressPrefixes = []string{"Resolving deltas:"}
+
+// PushOutcome describes what happened to an object in a batch push
+type PushOutcome string
+
+const (
+	// PushOutcomePushed is recorded for objects pushed to the remote
+	PushOutcomePushed PushOutcome = "pushed"
+
+	// PushOutcomeSkipped is recorded for objects already in the remote
+	PushOutcomeSkipped PushOutcome = "skipped"
+
+	// PushOutcomeFailed is recorded for objects which could not be pushed
+	PushOutcomeFailed PushOutcome = "failed"
+)
+
+// PushReport contains the outcomes of the objects pushed by the batch
+// methods of the pusher
+type PushReport struct {
+	// Records of the pushed objects, slowest first
+	Records []PushRecord
+}
+
+// PushRecord is the outcome of pushing an object to a remote
+type PushRecord struct {
+	// Kind of the object, PushKindBranch or PushKindTag
+	Kind string
+
+	// Name of the branch or tag
+	Name string
+
+	// Remote the object was pushed to, with credentials masked
+	Remote string
+
+	// Time spent checking and pushing the object
+	Duration time.Duration
+
+	// What happened to the object
+	Outcome PushOutcome
+
+	// Error returned when the outcome is PushOutcomeFailed
+	Err error
+
+	// Objects sent to the remote, nil if git did not report them, eg when
+	// the remote already had all of them or in dry-run mode
+	Transfer *PushTransfer
+
+	// Lines printed by the remote during the push, eg by its hooks, with
+	// the "remote:" prefix and the credentials removed
+	RemoteMessages []string
+
+	// Number of push invocations made for the object, including retries.
+	// Zero if the object was skipped without pushing.
+	Attempts int
+
+	// Commit the local object pointed to when it was recorded, empty if it
+	// does not exist locally
+	SHA string
+}
+
+// PushTransfer counts the objects sent to the remote by a push
+type PushTransfer struct {
+	// Number of objects sent
+	Objects int
+
+	// Number of the sent objects which were deltified
+	Deltas int
+
+	// Approximate size of the sent pack, zero if git did not report it
+	Bytes int64
+}
+
+// String returns a human readable representation of the report
+func (r *PushReport) String() string {
+	var sb strings.Builder
+	var total time.Duration
+	for _, record := range r.Records {
+		total += record.Duration
+	}
+	sb.WriteString(fmt.Sprintf(
+		"Pushed %d objects in %s, %d git objects transferred\n",
+		len(r.Records), total, r.TransferredObjects(),
+	))
+	for _, record := range r.Records {
+		sb.WriteString(fmt.Sprintf(
+			"- %s %s to %s: %s in %s", record.Kind, record.Name,
+			record.Remote, record.Outcome, record.Duration,
+		))
+		if record.Transfer != nil {
+			sb.WriteString(fmt.Sprintf(
+				", %d objects (%d deltas)", record.Transfer.Objects, record.Transfer.Deltas,
+			))
+		}
+		if record.Err != nil {
+			sb.WriteString(fmt.Sprintf(" (%v)", record.Err))
+		}
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
+
+// TransferredObjects returns the number of git objects sent to the remotes
+// by all the recorded pushes which reported it
+func (r *PushReport) TransferredObjects() int {
+	objects := 0
+	for _, record := range r.Records {
+		if record.Transfer != nil {
+			objects += record.Transfer.Objects
+		}
+	}
+	return objects
+}
+
+// Report returns the outcomes and durations of all the objects pushed by the
+// batch methods (PushBranches, PushTags and PushTagToGroup) since the
+// pusher was created, sorted by duration. Objects are recorded as soon as
+// they are processed, so the report is complete even if a batch fails.
+func (gp *GitObjectPusher) Report() *PushReport {
+	gp.recordsMtx.Lock()
+	defer gp.recordsMtx.Unlock()
+
+	records := make([]PushRecord, len(gp.records))
+	copy(records, gp.records)
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].Duration > records[j].Duration
+	})
+	return &PushReport{Records: records}
+}
+
+// SummaryMarkdown renders the objects recorded in the session report as a
+// Markdown table, eg for the description of a release pull request. Objects
+// are listed in the order they were processed, with their kind, remote,
+// outcome, push attempts and commit. Rendering cannot fail, the error is
+// always nil.
+func (gp *GitObjectPusher) SummaryMarkdown() (string, error) {
+	gp.recordsMtx.Lock()
+	records := make([]PushRecord, len(gp.records))
+	copy(records, gp.records)
+	gp.recordsMtx.Unlock()
+
+	outcomes := map[PushOutcome]int{}
+	for _, record := range records {
+		outcomes[record.Outcome]++
+	}
+
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf(
+		"%d objects pushed, %d skipped and %d failed\n\n",
+		outcomes[PushOutcomePushed], outcomes[PushOutcomeSkipped], outcomes[PushOutcomeFailed],
+	))
+	if len(records) == 0 {
+		return sb.String(), nil
+	}
+	sb.WriteString("| Object | Type | Remote | Action | Attempts | SHA |\n")
+	sb.WriteString("| --- | --- | --- | --- | --- | --- |\n")
+	for _, record := range records {
+		sha := "-"
+		if record.SHA != "" {
+			sha = "`" + record.SHA + "`"
+		}
+		sb.WriteString(fmt.Sprintf(
+			"| %s | %s | %s | %s | %d | %s |\n",
+			markdownCell(record.Name), record.Kind, markdownCell(record.Remote),
+			record.Outcome, record.Attempts, sha,
+		))
+	}
+	return sb.String(), nil
+}
+
+// markdownCell escapes the characters of a value breaking a Markdown table
+// cell
+func markdownCell(value string) string {
+	return strings.NewReplacer("|", "\\|", "\n", " ").Replace(value)
+}
+
+// recordPush adds the outcome of pushing an object to the session report
+func (gp *GitObjectPusher) recordPush(
+	kind, name, remote string, start time.Time, pushed bool, err error,
+) {
+	record := PushRecord{
+		Kind:     kind,
+		Name:     name,
+		Remote:   displayRemote(remote),
+		Duration: gp.clock().Now().Sub(start),
+		Outcome:  PushOutcomeSkipped,
+		Err:      err,
+		SHA:      gp.recordedCommit(kind, name),
+	}
+	if err != nil {
+		record.Outcome = PushOutcomeFailed
+	} else if pushed {
+		record.Outcome = PushOutcomePushed
+	}
+
+	gp.recordsMtx.Lock()
+	defer gp.recordsMtx.Unlock()
+	key := transferKey(remote, kind, name)
+	record.Transfer = gp.transfers[key]
+	delete(gp.transfers, key)
+	record.RemoteMessages = gp.remoteMessages[key]
+	delete(gp.remoteMessages, key)
+	record.Attempts = gp.attempts[key]
+	delete(gp.attempts, key)
+	gp.records = append(gp.records, record)
+}
+
+// recordedCommit returns the commit a recorded object points to in the local
+// repository, or an empty string if it cannot be resolved
+func (gp *GitObjectPusher) recordedCommit(kind, name string) string {
+	ref := name
+	switch kind {
+	case PushKindBranch:
+		ref = branchRefPrefix + name
+	case PushKindTag:
+		ref = gp.tagRef(name)
+	}
+	commit, err := gp.resolveCommit(ref)
+	if err != nil {
+		return ""
+	}
+	return commit
+}
+
+// countAttempt counts a push invocation of a ref until the push is recorded
+func (gp *GitObjectPusher) countAttempt(remote, ref string) {
+	kind, name := gp.refObject(ref)
+	key := transferKey(remote, kind, name)
+
+	gp.recordsMtx.Lock()
+	defer gp.recordsMtx.Unlock()
+	if gp.attempts == nil {
+		gp.attempts = map[string]int{}
+	}
+	gp.attempts[key]++
+}
+
+// storeTransfer keeps the objects sent by the push of a ref until the push
+// is recorded
+func (gp *GitObjectPusher) storeTransfer(remote, ref string, transfer *PushTransfer) {
+	kind, name := gp.refObject(ref)
+	key := transferKey(remote, kind, name)
+
+	gp.recordsMtx.Lock()
+	defer gp.recordsMtx.Unlock()
+	gp.stats.Pushes++
+	if gp.transfers == nil {
+		gp.transfers = map[string]*PushTransfer{}
+	}
+	if transfer == nil {
+		delete(gp.transfers, key)
+		return
+	}
+	gp.transfers[key] = transfer
+	gp.stats.Objects += transfer.Objects
+	gp.stats.Bytes += transfer.Bytes
+}
+
+// storeRemoteMessages keeps the messages printed by the remote during the
+// push of a ref until the push is recorded
+func (gp *GitObjectPusher) storeRemoteMessages(remote, ref string, messages []string) {
+	kind, name := gp.refObject(ref)
+	key := transferKey(remote, kind, name)
+
+	gp.recordsMtx.Lock()
+	defer gp.recordsMtx.Unlock()
+	if gp.remoteMessages == nil {
+		gp.remoteMessages = map[string][]string{}
+	}
+	if len(messages) == 0 {
+		delete(gp.remoteMessages, key)
+		return
+	}
+	gp.remoteMessages[key] = messages
+}
+
+// transferKey identifies the push of an object to a remote, the kind keeps a
+// branch and a tag with the same name apart
+func transferKey(remote, kind, name string) string {
+	return remote + " " + kind + " " + name
+}
+
+// parsePushTransfer reads the objects sent from the error output of git
+// push, it returns nil if they are not reported
+func parsePushTransfer(output string) *PushTransfer {
+	match := pushTotalRegex.FindStringSubmatch(output)
+	if match == nil {
+		return nil
+	}
+	objects, err := strconv.Atoi(match[1])
+	if err != nil {
+		return nil
+	}
+	deltas, err := strconv.Atoi(match[2])
+	if err != nil {
+		return nil
+	}
+	transfer := &PushTransfer{Objects: objects, Deltas: deltas}
+
+	// The progress line is rewritten with carriage returns, the last
+	// occurrence holds the final size
+	written := pushWrittenRegex.FindAllStringSubmatch(output, -1)
+	if len(written) > 0 {
+		last := written[len(written)-1]
+		if size, err := strconv.ParseFloat(last[1], 64); err == nil {
+			transfer.Bytes = int64(size * byteUnits[last[2]])
+		}
+	}
+	return transfer
+}
+
+// stripPushProgress removes the progress lines from the error output of git
+// push, keeping the messages of git and the remote
+func stripPushProgress(output string) string {
+	lines := []string{}
+	for _, line := range strings.Split(output, "\n") {
+		progress := false
+		for _, prefix := range pushProgressPrefixes {
+			if strings.HasPrefix(line, prefix) {
+				progress = true
+				break
+			}
+		}
+		if !progress {
+			lines = append(lines, line)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// parseRemoteMessages extracts the lines printed by the remote from the error
+// output of git push, without their prefix. Blank lines and the progress of
+// the remote are dropped.
+func parseRemoteMessages(output string) []string {
+	messages := []string{}
+	for _, line := range strings.Split(output, "\n") {
+		// The progress of the remote is rewritten with carriage returns
+		if i := strings.LastIndex(line, "\r"); i >= 0 {
+			line = line[i+1:]
+		}
+		if !strings.HasPrefix(line, remoteMessagePrefix) {
+			continue
+		}
+		message := strings.TrimSpace(strings.TrimPrefix(line, remoteMessagePrefix))
+		if message == "" {
+			continue
+		}
+		progress := false
+		for _, prefix := range remoteProgressPrefixes {
+			if strings.HasPrefix(message, prefix) {
+				progress = true
+				break
+			}
+		}
+		if !progress {
+			messages = append(messages, message)
+		}
+	}
+	return messages
+}
@@ -0,0 +1,428 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package release
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"k8s.io/release/pkg/command"
+)
+
+const (
+	gpgExecutable = "gpg"
+
+	// SigningFormatOpenPGP signs tags using GPG
+	SigningFormatOpenPGP = "openpgp"
+
+	// SigningFormatSSH signs tags using an SSH key
+	SigningFormatSSH = "ssh"
+
+	// coSignNotesRef stores the counter-signatures of the tags as git notes
+	// attached to the tag objects
+	coSignNotesRef = "refs/notes/release-cosign"
+
+	// validSigStatus prefixes the gpg status line with the fingerprint of a
+	// valid signature
+	validSigStatus = "[GNUPG:] VALIDSIG "
+)
+
+var (
+	// ErrSigningTokenAbsent is returned when signing with a hardware token
+	// which is not plugged in or not reachable by gpg
+	ErrSigningTokenAbsent = errors.New("signing token is not present")
+
+	// ErrSigningTokenPINRequired is returned when the hardware signing token
+	// requires a PIN which is neither cached nor configured
+	ErrSigningTokenPINRequired = errors.New("signing token requires a PIN which is not available")
+
+	// ErrUnsignedTag is returned when pushing a tag without a signature
+	// while RequirePresignedTags is set
+	ErrUnsignedTag = errors.New("tag is not signed")
+)
+
+// signingTokenAbsentErrors are printed by gpg when the smartcard holding the
+// key is missing, in lowercase
+var signingTokenAbsentErrors = []string{
+	"card not present", "card removed", "no such device",
+	"no smartcard daemon", "openpgp card not available", "selecting card failed",
+}
+
+// signingTokenPINErrors are printed by gpg when the PIN of the smartcard is
+// required and cannot be asked for, or is wrong, in lowercase
+var signingTokenPINErrors = []string{
+	"no pinentry", "operation cancelled", "bad pin", "bad passphrase", "pin blocked",
+}
+
+// validateSigningOptions checks that the signing options are consistent
+func validateSigningOptions(opts *GitObjectPusherOptions) error {
+	switch opts.SigningFormat {
+	case "", SigningFormatOpenPGP:
+	case SigningFormatSSH:
+		if !opts.SignTags {
+			break
+		}
+		if opts.SigningKey == "" {
+			return errors.New("ssh signing requires a signing key")
+		}
+		if opts.SSHAllowedSignersFile == "" {
+			return errors.New("ssh signing requires an allowed signers file to verify signatures")
+		}
+	default:
+		return errors.Errorf("unsupported signing format %s", opts.SigningFormat)
+	}
+
+	if opts.SigningToken {
+		if !opts.SignTags {
+			return errors.New("signing token requires signing tags")
+		}
+		if opts.SigningFormat == SigningFormatSSH {
+			return errors.New("signing token is only supported with openpgp signatures")
+		}
+	}
+	if opts.SigningTokenPINFile != "" {
+		if !opts.SigningToken {
+			return errors.New("signing token PIN file requires a signing token")
+		}
+		if _, err := os.Stat(opts.SigningTokenPINFile); err != nil {
+			return errors.Wrap(err, "checking signing token PIN file")
+		}
+	}
+
+	if opts.RequirePresignedTags {
+		if opts.SignTags {
+			return errors.New("pre-signed tags conflict with signing tags")
+		}
+		if opts.SigningFormat == SigningFormatSSH && opts.SSHAllowedSignersFile == "" {
+			return errors.New("verifying ssh signatures requires an allowed signers file")
+		}
+	}
+
+	if opts.CoSigningKey != "" {
+		if !opts.SignTags {
+			return errors.New("co-signing requires signing tags")
+		}
+		if opts.SigningFormat == SigningFormatSSH {
+			return errors.New("co-signing is only supported with openpgp signatures")
+		}
+		if opts.CoSigningKey == opts.SigningKey {
+			return errors.New("co-signing key has to be different from the signing key")
+		}
+	}
+	return nil
+}
+
+// signingConfig returns the git configuration flags to sign and verify tags
+// according to the pusher options. The settings only apply to the single git
+// invocation they are passed to.
+func (gp *GitObjectPusher) signingConfig() []string {
+	args := []string{}
+	if gp.opts.SigningFormat != "" {
+		args = append(args, "-c", "gpg.format="+gp.opts.SigningFormat)
+	}
+	if gp.opts.SigningKey != "" {
+		args = append(args, "-c", "user.signingkey="+gp.opts.SigningKey)
+	}
+	if gp.opts.SSHAllowedSignersFile != "" {
+		args = append(args, "-c", "gpg.ssh.allowedSignersFile="+gp.opts.SSHAllowedSignersFile)
+	}
+	return args
+}
+
+// verifyTagSignature checks that a local tag carries a valid signature
+func (gp *GitObjectPusher) verifyTagSignature(tagName string) error {
+	args := append(gp.signingConfig(), "verify-tag", gp.tagRef(tagName))
+	if _, err := gp.runGitWithEnv(gp.signingEnv(), args...); err != nil {
+		return errors.Wrap(err, "tag signature is not valid")
+	}
+	logrus.Infof("Signature of tag %s verified", tagName)
+	return nil
+}
+
+// checkPresignedTag verifies that a local tag to be pushed was signed
+// beforehand and that its signature is valid
+func (gp *GitObjectPusher) checkPresignedTag(tagName string) error {
+	sha, err := gp.runGit("rev-parse", gp.tagRef(tagName))
+	if err != nil {
+		return errors.Wrapf(err, "resolving tag %s", tagName)
+	}
+	objType, err := gp.runGit("cat-file", "-t", sha)
+	if err != nil {
+		return errors.Wrapf(err, "reading type of tag %s", tagName)
+	}
+	if objType != "tag" {
+		return errors.Wrapf(
+			ErrUnsignedTag, "lightweight tag %s cannot carry a signature", tagName,
+		)
+	}
+	signed, err := gp.isSignedTag(sha)
+	if err != nil {
+		return errors.Wrapf(err, "checking signature of tag %s", tagName)
+	}
+	if !signed {
+		return errors.Wrapf(ErrUnsignedTag, "refusing to push tag %s", tagName)
+	}
+	if err := gp.verifyTagSignature(tagName); err != nil {
+		return errors.Wrapf(err, "verifying signature of tag %s", tagName)
+	}
+	return nil
+}
+
+// signingEnv returns the environment variables needed by git to sign and
+// verify tags
+func (gp *GitObjectPusher) signingEnv() []string {
+	if gp.opts.GnuPGHome == "" {
+		return nil
+	}
+	return []string{"GNUPGHOME=" + gp.opts.GnuPGHome}
+}
+
+// checkSigningAgent verifies that gpg is able to sign without asking for a
+// passphrase, which would block forever in non interactive environments.
+// This requires the gpg-agent to be available and holding the unlocked key.
+// Other signing formats are not checked.
+func (gp *GitObjectPusher) checkSigningAgent() error {
+	if gp.opts.SigningFormat != "" && gp.opts.SigningFormat != SigningFormatOpenPGP {
+		return nil
+	}
+
+	if gp.opts.SigningToken {
+		if err := gp.checkSigningToken(); err != nil {
+			return err
+		}
+	}
+
+	args := []string{"--batch", "--pinentry-mode", "error"}
+	if gp.opts.SigningTokenPINFile != "" {
+		// Signing once unlocks the token, the gpg-agent caches the PIN for
+		// the signatures made by git
+		args = []string{
+			"--batch", "--pinentry-mode", "loopback",
+			"--passphrase-file", gp.opts.SigningTokenPINFile,
+		}
+	}
+	if gp.opts.SigningKey != "" {
+		args = append(args, "--local-user", gp.opts.SigningKey)
+	}
+	// Sign empty input, the signature is written to the captured stdout
+	args = append(args, "--output", "-", "--sign", os.DevNull)
+
+	status, err := command.NewWithWorkDir(
+		gp.workDir(), gpgExecutable, args...,
+	).Env(gp.signingEnv()...).RunSilent()
+	if err != nil {
+		return errors.Wrap(err, "running gpg")
+	}
+	if !status.Success() {
+		if gp.opts.SigningToken {
+			return signingTokenError(status.Error())
+		}
+		return errors.Errorf(
+			"gpg is unable to sign without a passphrase prompt, make sure the "+
+				"gpg-agent is running and the signing key is unlocked: %s",
+			strings.TrimSpace(status.Error()),
+		)
+	}
+	logrus.Debug("Signing key is available in the gpg-agent")
+	return nil
+}
+
+// checkSigningToken verifies that the hardware token holding the signing key
+// is reachable by gpg. The card status is read in batch mode, so a missing
+// token fails right away instead of prompting for it to be inserted.
+func (gp *GitObjectPusher) checkSigningToken() error {
+	status, err := command.NewWithWorkDir(
+		gp.workDir(), gpgExecutable, "--batch", "--card-status",
+	).Env(gp.signingEnv()...).RunSilent()
+	if err != nil {
+		return errors.Wrap(err, "running gpg")
+	}
+	if !status.Success() {
+		return errors.Wrap(signingTokenError(status.Error()), "reading signing token status")
+	}
+	for _, line := range strings.Split(status.Output(), "\n") {
+		if strings.HasPrefix(line, "Serial number") {
+			logrus.Infof("Signing with the hardware token %s", strings.TrimSpace(
+				line[strings.Index(line, ":")+1:],
+			))
+		}
+	}
+	return nil
+}
+
+// signingTokenError maps the error output of gpg when using a hardware token
+// to ErrSigningTokenAbsent or ErrSigningTokenPINRequired, keeping the gpg
+// messages for context
+func signingTokenError(output string) error {
+	message := strings.TrimSpace(output)
+	lower := strings.ToLower(message)
+	for _, absent := range signingTokenAbsentErrors {
+		if strings.Contains(lower, absent) {
+			return errors.Wrapf(
+				ErrSigningTokenAbsent, "make sure the token is plugged in: %s", message,
+			)
+		}
+	}
+	for _, pin := range signingTokenPINErrors {
+		if strings.Contains(lower, pin) {
+			return errors.Wrapf(
+				ErrSigningTokenPINRequired,
+				"unlock the token in the gpg-agent or set a PIN file: %s", message,
+			)
+		}
+	}
+	return errors.Errorf("gpg is unable to sign with the signing token: %s", message)
+}
+
+// coSignTag adds a detached signature of the tag object made with the
+// CoSigningKey as a note in coSignNotesRef, unless the tag already has one
+func (gp *GitObjectPusher) coSignTag(tagName string) error {
+	sha, err := gp.runGit("rev-parse", gp.tagRef(tagName))
+	if err != nil {
+		return errors.Wrapf(err, "resolving tag %s", tagName)
+	}
+	if _, err := gp.runGit("notes", "--ref", coSignNotesRef, "show", sha); err == nil {
+		logrus.Infof("Tag %s is already co-signed, reusing the counter-signature", tagName)
+		return nil
+	}
+
+	tmpDir, err := gp.writeTagObject(sha)
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	logrus.Infof("Co-signing tag %s", tagName)
+	signatureFile := filepath.Join(tmpDir, "signature.asc")
+	if _, err := gp.runGPG(
+		"--local-user", gp.opts.CoSigningKey, "--armor",
+		"--output", signatureFile, "--detach-sign", filepath.Join(tmpDir, "tag"),
+	); err != nil {
+		return errors.Wrap(err, "creating counter-signature")
+	}
+	if _, err := gp.runGit(append(
+		gp.identityConfig(), "notes", "--ref", coSignNotesRef, "add", "--file", signatureFile, sha,
+	)...); err != nil {
+		return errors.Wrap(err, "storing counter-signature")
+	}
+	return nil
+}
+
+// verifyCoSignature checks that a tag carries a valid signature and a valid
+// counter-signature, made by two different keys
+func (gp *GitObjectPusher) verifyCoSignature(tagName string) error {
+	status, err := gp.gitCommand(
+		append(gp.signingConfig(), "verify-tag", "--raw", gp.tagRef(tagName))...,
+	).Env(gp.signingEnv()...).RunSilent()
+	if err != nil {
+		return errors.Wrap(err, "running git verify-tag")
+	}
+	signer := signerFingerprint(status.Error())
+	if !status.Success() || signer == "" {
+		return errors.Errorf("tag signature is not valid: %s", strings.TrimSpace(status.Error()))
+	}
+
+	sha, err := gp.runGit("rev-parse", gp.tagRef(tagName))
+	if err != nil {
+		return errors.Wrapf(err, "resolving tag %s", tagName)
+	}
+	signature, err := gp.runGit("notes", "--ref", coSignNotesRef, "show", sha)
+	if err != nil {
+		return errors.Errorf("tag %s has no counter-signature", tagName)
+	}
+	tmpDir, err := gp.writeTagObject(sha)
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+	signatureFile := filepath.Join(tmpDir, "signature.asc")
+	if err := ioutil.WriteFile(
+		signatureFile, []byte(signature+"\n"), os.FileMode(0o600),
+	); err != nil {
+		return errors.Wrap(err, "writing counter-signature")
+	}
+
+	output, err := gp.runGPG(
+		"--status-fd", "1", "--verify", signatureFile, filepath.Join(tmpDir, "tag"),
+	)
+	if err != nil {
+		return errors.Wrap(err, "counter-signature is not valid")
+	}
+	coSigner := signerFingerprint(output)
+	if coSigner == "" {
+		return errors.New("counter-signature is not valid")
+	}
+	if coSigner == signer {
+		return errors.Errorf("tag is signed and counter-signed by the same key %s", signer)
+	}
+	logrus.Infof("Signature and counter-signature of tag %s verified", tagName)
+	return nil
+}
+
+// writeTagObject stores the content of a tag object in a new temporary
+// directory, which has to be removed by the caller
+func (gp *GitObjectPusher) writeTagObject(sha string) (string, error) {
+	content, err := gp.gitCommand("cat-file", "tag", sha).RunSilentSuccessOutput()
+	if err != nil {
+		return "", errors.Wrap(err, "reading tag object")
+	}
+	tmpDir, err := ioutil.TempDir("", "release-cosign-")
+	if err != nil {
+		return "", errors.Wrap(err, "creating temporary directory")
+	}
+	if err := ioutil.WriteFile(
+		filepath.Join(tmpDir, "tag"), []byte(content.Output()), os.FileMode(0o600),
+	); err != nil {
+		os.RemoveAll(tmpDir)
+		return "", errors.Wrap(err, "writing tag object")
+	}
+	return tmpDir, nil
+}
+
+// runGPG executes gpg non interactively with the signing environment
+func (gp *GitObjectPusher) runGPG(args ...string) (string, error) {
+	status, err := command.NewWithWorkDir(
+		gp.workDir(), gpgExecutable,
+		append([]string{"--batch", "--pinentry-mode", "error"}, args...)...,
+	).Env(gp.signingEnv()...).RunSilent()
+	if err != nil {
+		return "", errors.Wrap(err, "running gpg")
+	}
+	if !status.Success() {
+		return "", errors.Errorf("gpg failed: %s", strings.TrimSpace(status.Error()))
+	}
+	return status.Output(), nil
+}
+
+// signerFingerprint returns the fingerprint of the key which made a valid
+// signature from the gpg status output, or an empty string if there is none
+func signerFingerprint(statusOutput string) string {
+	for _, line := range strings.Split(statusOutput, "\n") {
+		if strings.HasPrefix(line, validSigStatus) {
+			fields := strings.Fields(strings.TrimPrefix(line, validSigStatus))
+			if len(fields) > 0 {
+				return fields[0]
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,150 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package release
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// defaultSSHPort is the port of the ssh remotes without an explicit one
+const defaultSSHPort = 22
+
+// scpLikeURLRegex matches the scp like syntax of ssh remotes, eg
+// git@github.com:kubernetes/kubernetes.git
+var scpLikeURLRegex = regexp.MustCompile(`^(?:[^@/]+@)?([^:/]+):`)
+
+// sshArgs returns the arguments appended to the ssh command to apply the
+// SSHPort and SSHJumpHost options. They reach every git command contacting
+// the remote, not only the pushes, through remoteEnv.
+func (gp *GitObjectPusher) sshArgs() string {
+	args := ""
+	if gp.opts.SSHJumpHost != "" {
+		args += " -J " + gp.opts.SSHJumpHost
+	}
+	if gp.opts.SSHPort != 0 {
+		args += fmt.Sprintf(" -p %d", gp.opts.SSHPort)
+	}
+	return args
+}
+
+// verifyRemoteURL checks that the push URL of the remote matches the
+// ExpectedRemoteURL, if set. Only the remote of the pusher is verified, not
+// the additional ones of remote groups.
+func (gp *GitObjectPusher) verifyRemoteURL(remote string) error {
+	if gp.opts.ExpectedRemoteURL == "" || remote != gp.remote() {
+		return nil
+	}
+
+	remoteURL := remote
+	if !strings.ContainsAny(remote, ":/") {
+		output, err := gp.runGit("remote", "get-url", "--push", remote)
+		if err != nil {
+			return errors.Wrapf(err, "resolving the URL of remote %s", remote)
+		}
+		remoteURL = output
+	}
+	if normalizeRemoteURL(remoteURL) != normalizeRemoteURL(gp.opts.ExpectedRemoteURL) {
+		return errors.Wrapf(
+			ErrUnexpectedRemote, "%s points to %s, expected %s", remote,
+			displayRemote(remoteURL), displayRemote(gp.opts.ExpectedRemoteURL),
+		)
+	}
+	return nil
+}
+
+// normalizeRemoteURL reduces a remote URL to its host and path, so that the
+// ssh, scp like and https URLs of a repository compare equal. Local paths
+// are only cleaned.
+func normalizeRemoteURL(remoteURL string) string {
+	normalized := strings.TrimSpace(remoteURL)
+	if strings.Contains(normalized, "://") {
+		parsed, err := url.Parse(normalized)
+		if err == nil {
+			normalized = strings.ToLower(parsed.Hostname()) + "/" + strings.TrimPrefix(parsed.Path, "/")
+		}
+	} else if match := scpLikeURLRegex.FindStringSubmatch(normalized); match != nil {
+		normalized = strings.ToLower(match[1]) + "/" + strings.TrimPrefix(
+			normalized[len(match[0]):], "/",
+		)
+	} else {
+		normalized = filepath.Clean(normalized)
+	}
+	normalized = strings.TrimSuffix(strings.TrimSuffix(normalized, "/"), ".git")
+	return strings.TrimSuffix(normalized, "/")
+}
+
+// remoteEndpoint returns the host:port a remote is reached at, to be shown
+// in connection errors. It returns an empty string if the URL of the remote
+// cannot be resolved or it is a local path.
+func (gp *GitObjectPusher) remoteEndpoint(remote string) string {
+	remoteURL := remote
+	if !strings.ContainsAny(remote, ":/") {
+		output, err := gp.runGit("remote", "get-url", remote)
+		if err != nil {
+			logrus.Debugf("Unable to resolve the URL of remote %s: %v", remote, err)
+			return ""
+		}
+		remoteURL = output
+	}
+	return parseRemoteEndpoint(remoteURL, gp.opts.SSHPort)
+}
+
+// parseRemoteEndpoint extracts the host:port from a remote URL. The sshPort
+// is used for ssh URLs without a port, unless it is zero.
+func parseRemoteEndpoint(remoteURL string, sshPort int) string {
+	if sshPort == 0 {
+		sshPort = defaultSSHPort
+	}
+
+	if !strings.Contains(remoteURL, "://") {
+		match := scpLikeURLRegex.FindStringSubmatch(remoteURL)
+		if match == nil {
+			return ""
+		}
+		return net.JoinHostPort(match[1], strconv.Itoa(sshPort))
+	}
+
+	parsed, err := url.Parse(remoteURL)
+	if err != nil || parsed.Hostname() == "" {
+		return ""
+	}
+	port := parsed.Port()
+	if port == "" {
+		switch parsed.Scheme {
+		case "ssh", "git+ssh", "ssh+git":
+			port = strconv.Itoa(sshPort)
+		case "https":
+			port = "443"
+		case "http":
+			port = "80"
+		case "git":
+			port = "9418"
+		default:
+			return ""
+		}
+	}
+	return net.JoinHostPort(parsed.Hostname(), port)
+}
@@ -0,0 +1,134 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package release
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// batchStateVersion is the version of the state file format written by the
+// pusher. Files with a different version are rejected.
+const batchStateVersion = 1
+
+// batchState is the progress of the batch pushes persisted in the state file
+// to be able to resume them
+type batchState struct {
+	// Version of the file format
+	Version int `json:"version"`
+
+	// Remote the objects were pushed to, with credentials masked
+	Remote string `json:"remote"`
+
+	// Full names of the refs already pushed or found in the remote, sorted
+	Completed []string `json:"completed"`
+
+	path      string
+	completed map[string]bool
+
+	// Dry runs push nothing, so they must not record anything as completed
+	readOnly bool
+}
+
+// loadBatchState reads the state file set in the StateFile option, returning
+// an empty state if it does not exist yet. The state is nil if no state file
+// is configured. In dry-run mode the state is never saved.
+func (gp *GitObjectPusher) loadBatchState() (*batchState, error) {
+	if gp.opts.StateFile == "" {
+		return nil, nil
+	}
+
+	state := &batchState{
+		Version:   batchStateVersion,
+		Remote:    displayRemote(gp.remote()),
+		Completed: []string{},
+		path:      gp.opts.StateFile,
+		completed: map[string]bool{},
+		readOnly:  gp.opts.DryRun,
+	}
+	data, err := ioutil.ReadFile(gp.opts.StateFile)
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "reading batch state file")
+	}
+
+	remote := state.Remote
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, errors.Wrapf(err, "parsing batch state file %s", gp.opts.StateFile)
+	}
+	if state.Version != batchStateVersion {
+		return nil, errors.Errorf(
+			"unsupported batch state file version %d, expected %d",
+			state.Version, batchStateVersion,
+		)
+	}
+	if state.Remote != remote {
+		return nil, errors.Errorf(
+			"batch state file belongs to remote %s, not %s", state.Remote, remote,
+		)
+	}
+	for _, ref := range state.Completed {
+		state.completed[ref] = true
+	}
+	logrus.Infof(
+		"Resuming batch from %s with %d completed objects", gp.opts.StateFile, len(state.Completed),
+	)
+	return state, nil
+}
+
+// isCompleted returns true if the ref was completed in a previous run
+func (s *batchState) isCompleted(ref string) bool {
+	return s != nil && s.completed[ref]
+}
+
+// complete marks a ref as completed and saves the state file, unless the
+// state is read-only. The file is replaced atomically so that it is never
+// left half written.
+func (s *batchState) complete(ref string) error {
+	if s == nil || s.completed[ref] || s.readOnly {
+		return nil
+	}
+	s.completed[ref] = true
+	s.Completed = append(s.Completed, ref)
+	sort.Strings(s.Completed)
+
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "marshaling batch state")
+	}
+	tmpFile, err := ioutil.TempFile(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		return errors.Wrap(err, "creating temporary batch state file")
+	}
+	defer os.Remove(tmpFile.Name())
+	if _, err := tmpFile.Write(append(data, '\n')); err != nil {
+		tmpFile.Close()
+		return errors.Wrap(err, "writing batch state file")
+	}
+	if err := tmpFile.Close(); err != nil {
+		return errors.Wrap(err, "closing batch state file")
+	}
+	return errors.Wrap(os.Rename(tmpFile.Name(), s.path), "replacing batch state file")
+}
@@ -0,0 +1,80 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package release
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// SessionStats aggregates the pushes made by a pusher
+type SessionStats struct {
+	// Number of successful git push invocations
+	Pushes int
+
+	// Number of git objects sent to the remotes
+	Objects int
+
+	// Approximate number of bytes sent to the remotes
+	Bytes int64
+
+	// Time since the pusher was created
+	Duration time.Duration
+
+	// Number of push attempts retried after network errors
+	Retries int
+}
+
+// StatsSink receives the statistics of a pusher session
+type StatsSink interface {
+	// ReportStats is called once by EndSession with the session statistics
+	ReportStats(stats SessionStats) error
+}
+
+// noopStatsSink discards the statistics, it is used when no StatsSink is set
+type noopStatsSink struct{}
+
+// ReportStats does nothing
+func (noopStatsSink) ReportStats(SessionStats) error {
+	return nil
+}
+
+// EndSession delivers the aggregated statistics of all the pushes made since
+// the pusher was created to the StatsSink
+func (gp *GitObjectPusher) EndSession() error {
+	gp.recordsMtx.Lock()
+	stats := gp.stats
+	gp.recordsMtx.Unlock()
+	stats.Duration = gp.clock().Now().Sub(gp.sessionStart)
+
+	sink := gp.opts.StatsSink
+	if sink == nil {
+		sink = noopStatsSink{}
+	}
+	if err := sink.ReportStats(stats); err != nil {
+		return errors.Wrap(err, "reporting session stats")
+	}
+	return nil
+}
+
+// countRetry adds a retried push attempt to the session statistics
+func (gp *GitObjectPusher) countRetry() {
+	gp.recordsMtx.Lock()
+	defer gp.recordsMtx.Unlock()
+	gp.stats.Retries++
+}
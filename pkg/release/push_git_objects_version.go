@@ -0,0 +1,103 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package release
+
+import (
+	"regexp"
+
+	"github.com/blang/semver"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"k8s.io/release/pkg/command"
+)
+
+// gitVersionRegex matches the version in the output of git version, eg
+// "git version 2.39.2" or "git version 2.37.1 (Apple Git-137.1)"
+var gitVersionRegex = regexp.MustCompile(`(\d+)\.(\d+)(?:\.(\d+))?`)
+
+var (
+	// signedPushGitVersion is the first git version supporting push --signed
+	signedPushGitVersion = semver.MustParse("2.2.0")
+
+	// sshSigningGitVersion is the first git version able to sign with ssh
+	// keys
+	sshSigningGitVersion = semver.MustParse("2.34.0")
+)
+
+// gitRequirement is the minimum git version needed by a pusher feature
+type gitRequirement struct {
+	feature string
+	version semver.Version
+}
+
+// checkGitVersion verifies that the installed git is recent enough for the
+// MinGitVersion and the enabled features. Git is only queried if any of them
+// has requirements.
+func checkGitVersion(opts *GitObjectPusherOptions) error {
+	requirements := []gitRequirement{}
+	if opts.MinGitVersion != "" {
+		version, err := parseGitVersion(opts.MinGitVersion)
+		if err != nil {
+			return errors.Wrap(err, "parsing minimum git version")
+		}
+		requirements = append(requirements, gitRequirement{"the pusher", version})
+	}
+	if opts.SignedPush {
+		requirements = append(requirements, gitRequirement{"signed pushes", signedPushGitVersion})
+	}
+	if (opts.SignTags || opts.SignedPush || opts.RequirePresignedTags) &&
+		opts.SigningFormat == SigningFormatSSH {
+		requirements = append(requirements, gitRequirement{"ssh signing", sshSigningGitVersion})
+	}
+	if len(requirements) == 0 {
+		return nil
+	}
+
+	output, err := command.New(gitExecutable, "version").RunSilentSuccessOutput()
+	if err != nil {
+		return errors.Wrap(err, "checking git version")
+	}
+	installed, err := parseGitVersion(output.OutputTrimNL())
+	if err != nil {
+		return errors.Wrap(err, "parsing installed git version")
+	}
+	logrus.Debugf("Found git version %s", installed)
+
+	for _, requirement := range requirements {
+		if installed.LT(requirement.version) {
+			return errors.Errorf(
+				"git %s is installed, but %s requires at least git %s",
+				installed, requirement.feature, requirement.version,
+			)
+		}
+	}
+	return nil
+}
+
+// parseGitVersion reads a version like 2.39 or 2.39.2 from a string, eg the
+// output of git version. Suffixes like .windows.1 are ignored.
+func parseGitVersion(s string) (semver.Version, error) {
+	match := gitVersionRegex.FindStringSubmatch(s)
+	if match == nil {
+		return semver.Version{}, errors.Errorf("no version found in %q", s)
+	}
+	patch := match[3]
+	if patch == "" {
+		patch = "0"
+	}
+	return semver.Parse(match[1] + "." + match[2] + "." + patch)
+}
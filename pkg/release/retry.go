@@ -0,0 +1,153 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package release
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"k8s.io/release/pkg/git"
+)
+
+// defaultRetryBackoff is the time waited before the first retry when no
+// InitialBackoff is set in the RetryOptions
+const defaultRetryBackoff = time.Second
+
+// maxInfiniteRetryBackoff caps the wait time between attempts when retrying
+// infinitely
+const maxInfiniteRetryBackoff = 5 * time.Minute
+
+// Clock provides the current time and waits, so that the retry timing can
+// be controlled in tests
+type Clock interface {
+	// Now returns the current time
+	Now() time.Time
+
+	// Sleep waits for the duration, returning early with the context error
+	// if the context is done
+	Sleep(ctx context.Context, d time.Duration) error
+}
+
+// realClock is the Clock backed by the system time
+type realClock struct{}
+
+// Now returns the current system time
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+// Sleep waits for the duration or until the context is done
+func (realClock) Sleep(ctx context.Context, d time.Duration) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(d):
+		return nil
+	}
+}
+
+// RetryOptions controls how Retry executes an operation
+type RetryOptions struct {
+	// Number of times the operation is retried after the first attempt
+	// failed. Setting it to 0 disables retrying, the operation is attempted
+	// exactly once.
+	MaxRetries int
+
+	// Retry until the operation succeeds, fails with an error which cannot
+	// be retried or the context is done, ignoring MaxRetries. The wait time
+	// between attempts is capped to five minutes.
+	Infinite bool
+
+	// Time to wait before the first retry. The wait time doubles after every
+	// attempt. Defaults to one second.
+	InitialBackoff time.Duration
+
+	// Decides if an error is temporary and the operation can be retried.
+	// Defaults to IsRetryableGitError.
+	IsRetryable func(error) bool
+
+	// Called with the number of the failed attempt and its error before
+	// waiting to retry, eg to log or alert on retry patterns
+	OnRetry func(attempt int, err error)
+
+	// Clock used to wait between attempts. Defaults to the system clock.
+	Clock Clock
+}
+
+// Retry runs fn until it succeeds, it returns an error which cannot be
+// retried or the retries are exhausted. Between attempts it waits with an
+// exponential backoff, returning early if the context is done.
+func Retry(ctx context.Context, opts RetryOptions, fn func() error) error {
+	isRetryable := opts.IsRetryable
+	if isRetryable == nil {
+		isRetryable = IsRetryableGitError
+	}
+	backoff := opts.InitialBackoff
+	if backoff <= 0 {
+		backoff = defaultRetryBackoff
+	}
+	clock := opts.Clock
+	if clock == nil {
+		clock = realClock{}
+	}
+
+	var err error
+	for attempt := 1; ; attempt++ {
+		if err = fn(); err == nil {
+			return nil
+		}
+		if !isRetryable(err) {
+			return err
+		}
+		if attempt > opts.MaxRetries && !opts.Infinite {
+			break
+		}
+
+		if opts.Infinite {
+			logrus.Errorf("Attempt %d failed (will retry in %s): %v", attempt, backoff, err)
+		} else {
+			logrus.Errorf(
+				"Attempt %d failed (will retry %d more times in %s): %v",
+				attempt, opts.MaxRetries-attempt+1, backoff, err,
+			)
+		}
+		if opts.OnRetry != nil {
+			opts.OnRetry(attempt, err)
+		}
+		if sleepErr := clock.Sleep(ctx, backoff); sleepErr != nil {
+			return errors.Wrapf(sleepErr, "retrying after error: %v", err)
+		}
+		backoff *= 2
+		if opts.Infinite && backoff > maxInfiniteRetryBackoff {
+			backoff = maxInfiniteRetryBackoff
+		}
+	}
+
+	if opts.MaxRetries == 0 {
+		return err
+	}
+	return errors.Wrapf(err, "giving up after %d retries", opts.MaxRetries)
+}
+
+// IsRetryableGitError returns true if the error of a git operation is
+// considered temporary, like network failures
+func IsRetryableGitError(err error) bool {
+	return git.NewNetworkError(err).CanRetry()
+}
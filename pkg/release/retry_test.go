@@ -0,0 +1,160 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package release
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetry(t *testing.T) {
+	temporaryErr := errors.New("ssh: connect to host github.com port 22: connection refused")
+	permanentErr := errors.New("remote rejected")
+
+	for _, tc := range []struct {
+		maxRetries       int
+		errs             []error
+		expectedAttempts int
+		shouldErr        bool
+	}{
+		{ // Succeeds at first
+			maxRetries: 3, errs: []error{nil}, expectedAttempts: 1,
+		},
+		{ // Succeeds after retrying
+			maxRetries: 3, errs: []error{temporaryErr, temporaryErr, nil}, expectedAttempts: 3,
+		},
+		{ // Retries exhausted
+			maxRetries: 2, errs: []error{temporaryErr, temporaryErr, temporaryErr, nil},
+			expectedAttempts: 3, shouldErr: true,
+		},
+		{ // Permanent errors are not retried
+			maxRetries: 3, errs: []error{permanentErr, nil}, expectedAttempts: 1, shouldErr: true,
+		},
+		{ // No retries
+			maxRetries: 0, errs: []error{temporaryErr, nil}, expectedAttempts: 1, shouldErr: true,
+		},
+	} {
+		attempts := 0
+		retried := 0
+		err := Retry(
+			context.Background(),
+			RetryOptions{
+				MaxRetries:     tc.maxRetries,
+				InitialBackoff: time.Millisecond,
+				OnRetry: func(attempt int, err error) {
+					retried++
+					require.Equal(t, attempts, attempt)
+					require.Equal(t, temporaryErr, err)
+				},
+			},
+			func() error {
+				attempts++
+				return tc.errs[attempts-1]
+			},
+		)
+		require.Equal(t, tc.expectedAttempts, attempts)
+		require.Equal(t, tc.expectedAttempts-1, retried)
+		if tc.shouldErr {
+			require.NotNil(t, err)
+		} else {
+			require.Nil(t, err)
+		}
+	}
+}
+
+func TestRetryContextCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	attempts := 0
+	err := Retry(ctx, RetryOptions{MaxRetries: 5, InitialBackoff: time.Hour}, func() error {
+		attempts++
+		return errors.New("dial tcp: i/o timeout")
+	})
+	require.NotNil(t, err)
+	require.Equal(t, 1, attempts)
+}
+
+func TestRetryInfinite(t *testing.T) {
+	attempts := 0
+	err := Retry(
+		context.Background(),
+		RetryOptions{Infinite: true, InitialBackoff: time.Millisecond},
+		func() error {
+			attempts++
+			if attempts < 10 {
+				return errors.New("dial tcp: i/o timeout")
+			}
+			return nil
+		},
+	)
+	require.Nil(t, err)
+	require.Equal(t, 10, attempts)
+}
+
+// fakeClock records the waits without sleeping
+type fakeClock struct {
+	now    time.Time
+	sleeps []time.Duration
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) Sleep(ctx context.Context, d time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	c.sleeps = append(c.sleeps, d)
+	c.now = c.now.Add(d)
+	return nil
+}
+
+func TestRetryBackoff(t *testing.T) {
+	temporaryErr := errors.New("dial tcp: i/o timeout")
+
+	clock := &fakeClock{}
+	require.NotNil(t, Retry(
+		context.Background(), RetryOptions{MaxRetries: 3, Clock: clock},
+		func() error { return temporaryErr },
+	))
+	require.Equal(t, []time.Duration{time.Second, 2 * time.Second, 4 * time.Second}, clock.sleeps)
+
+	// Infinite retries cap the wait time
+	clock = &fakeClock{}
+	attempts := 0
+	require.Nil(t, Retry(
+		context.Background(),
+		RetryOptions{Infinite: true, InitialBackoff: time.Minute, Clock: clock},
+		func() error {
+			attempts++
+			if attempts < 6 {
+				return temporaryErr
+			}
+			return nil
+		},
+	))
+	require.Equal(t, []time.Duration{
+		time.Minute, 2 * time.Minute, 4 * time.Minute, 5 * time.Minute, 5 * time.Minute,
+	}, clock.sleeps)
+	require.Equal(t, 17*time.Minute, clock.Now().Sub(time.Time{}))
+}